@@ -6,15 +6,36 @@ import (
 	"github.com/jessevdk/go-flags"
 	_ "github.com/lib/pq"
 	"log"
+	"mail-service/internal/address"
+	"mail-service/internal/inbound"
 	"mail-service/internal/queue"
+	"mail-service/internal/schedule"
 	"mail-service/internal/services"
+	"mail-service/internal/services/attributes"
+	"mail-service/internal/services/client"
+	"mail-service/internal/services/complaint"
+	"mail-service/internal/services/confirm"
+	"mail-service/internal/services/export"
 	"mail-service/internal/services/group"
 	"mail-service/internal/services/img"
 	"mail-service/internal/services/mail"
+	"mail-service/internal/services/preferences"
+	"mail-service/internal/services/redirect"
+	"mail-service/internal/services/segments"
+	"mail-service/internal/services/stats"
+	"mail-service/internal/services/suppression"
+	"mail-service/internal/services/unsubscribe"
 	"mail-service/internal/services/user"
+	"mail-service/internal/services/webhooks"
 	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/verp"
+	"mail-service/internal/webhook"
+	"net/http"
 	"os"
 	"os/signal"
+	"time"
+	_ "time/tzdata"
 )
 
 type Options struct {
@@ -32,6 +53,23 @@ type Options struct {
 
 	RedisHost string `long:"redis-host" description:"Redis address" required:"true"`
 	RedisPort uint   `long:"redis-port" description:"Redis port" default:"6379"`
+
+	TrackingTTL   time.Duration `long:"tracking-ttl" description:"Lifetime of tracking links" default:"8760h"`
+	FlagAutomated bool          `long:"flag-automated-opens" description:"Don't count opens made by mailbox proxies and prefetchers"`
+
+	TrustedProxies []string `long:"trusted-proxy" description:"Address or CIDR network of a reverse proxy whose X-Forwarded-For header is trusted, can be repeated"`
+
+	OptInTTL time.Duration `long:"opt-in-ttl" description:"Time to confirm a subscription to a double opt-in group" default:"72h"`
+
+	DisposableDomains string `long:"disposable-domains" description:"File with email domains to reject, one per line"`
+
+	QuietHours    string   `long:"quiet-hours" description:"Daily window in the user's time zone during which non-urgent mails are deferred, e.g. 21:00-08:00"`
+	FrequencyCaps []string `long:"frequency-cap" description:"Max mails of a category per user per window, e.g. marketing:3/168h, can be repeated"`
+
+	InboundAddr  string `long:"inbound-addr" description:"Address of the inbound SMTP server for bounces, disabled if empty"`
+	BounceDomain string `long:"bounce-domain" description:"Domain of per-mail envelope senders which receives bounces"`
+
+	ComplaintsAddress string `long:"complaints-address" description:"Address of the inbound SMTP server which receives feedback loop reports"`
 }
 
 var appName = "mail-service"
@@ -63,6 +101,19 @@ func main() {
 		}
 	}(sqlStorage)
 
+	// Emails stored before they were normalized on write would miss lookups
+	// and duplicate checks.
+	normalized, duplicates, err := sqlStorage.NormalizeEmails(context.Background(), address.Normalize)
+	if err != nil {
+		log.Fatalf("Can't normalize emails: %v", err)
+	}
+	if normalized > 0 {
+		log.Printf("Normalized %d user emails", normalized)
+	}
+	for _, email := range duplicates {
+		log.Printf("Can't normalize email %q, the normalized email belongs to another user", email)
+	}
+
 	redisAddr := fmt.Sprintf("%s:%d", opts.RedisHost, opts.RedisPort)
 
 	delayedQueue, err := queue.NewQueue(context.Background(), redisAddr, os.Getenv("REDIS_PASSWORD"), 0)
@@ -72,10 +123,49 @@ func main() {
 	go delayedQueue.Run()
 	defer delayedQueue.Stop()
 
+	trackingKeys, err := token.ParseKeys(os.Getenv("TRACKING_KEYS"))
+	if err != nil {
+		log.Fatalf("Can't parse tracking keys: %v", err)
+	}
+	signer, err := token.NewSigner(trackingKeys, opts.TrackingTTL)
+	if err != nil {
+		log.Fatalf("Can't create tracking signer: %v", err)
+	}
+
+	proxies, err := client.ParseProxies(opts.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Can't parse trusted proxies: %v", err)
+	}
+
+	dispatcher := webhook.NewDispatcher(sqlStorage, &http.Client{Timeout: 10 * time.Second})
+	go dispatcher.Run()
+	defer dispatcher.Stop()
+
 	mailServerAddr := fmt.Sprintf("%s:%d", opts.SmtpHost, opts.SmtpPort)
 	smtpConf := mail.SmtpConfig{Addr: mailServerAddr, Username: opts.MailUsername, Password: opts.MailPassword}
 
-	mailSender, err := mail.NewWorker(opts.MailHost, smtpConf, sqlStorage, sqlStorage, delayedQueue)
+	var returnPaths *verp.Encoder
+	if opts.BounceDomain != "" {
+		returnPaths = verp.NewEncoder(opts.BounceDomain, signer)
+		smtpConf.ReturnPaths = returnPaths
+	}
+
+	policy := mail.Policy{Caps: map[string]mail.Cap{}}
+	if opts.QuietHours != "" {
+		policy.QuietHours, err = schedule.ParseQuietHours(opts.QuietHours)
+		if err != nil {
+			log.Fatalf("Can't parse quiet hours: %v", err)
+		}
+	}
+	for _, s := range opts.FrequencyCaps {
+		c, err := mail.ParseCap(s)
+		if err != nil {
+			log.Fatalf("Can't parse frequency cap: %v", err)
+		}
+		policy.Caps[c.Category] = c
+	}
+
+	mailSender, err := mail.NewWorker(opts.MailHost, smtpConf, sqlStorage, sqlStorage, sqlStorage, sqlStorage, delayedQueue, policy, signer, dispatcher)
 	if err != nil {
 		log.Fatalf("Can't create mail server: %v", err)
 	}
@@ -88,11 +178,33 @@ func main() {
 
 	go mailSender.Run()
 
+	complaints := inbound.NewComplaints(returnPaths, sqlStorage, sqlStorage, sqlStorage, sqlStorage, sqlStorage)
+	confirmHandlers := confirm.NewConfirmHandlers(opts.MailHost, opts.OptInTTL, sqlStorage, mailSender, signer)
+
+	var blocklist address.Blocklist
+	if opts.DisposableDomains != "" {
+		blocklist, err = address.LoadBlocklist(opts.DisposableDomains)
+		if err != nil {
+			log.Fatalf("Can't load disposable domains: %v", err)
+		}
+	}
+
 	h := services.NewMailServer(
-		user.NewUserHandlers(sqlStorage),
-		group.NewGroupHandlers(sqlStorage),
-		mail.NewMailHandlers(sqlStorage, sqlStorage, mailSender),
-		img.NewImageHandlers(sqlStorage),
+		user.NewUserHandlers(sqlStorage, sqlStorage, sqlStorage, delayedQueue, blocklist),
+		attributes.NewAttributeHandlers(sqlStorage),
+		export.NewExportHandlers(sqlStorage),
+		group.NewGroupHandlers(sqlStorage, confirmHandlers),
+		segments.NewSegmentHandlers(sqlStorage),
+		mail.NewMailHandlers(sqlStorage, sqlStorage, sqlStorage, mailSender),
+		stats.NewStatsHandlers(sqlStorage),
+		webhooks.NewWebhookHandlers(sqlStorage),
+		suppression.NewSuppressionHandlers(sqlStorage),
+		complaint.NewComplaintHandlers(complaints),
+		img.NewImageHandlers(sqlStorage, signer, dispatcher, proxies, opts.FlagAutomated),
+		redirect.NewRedirectHandlers(sqlStorage, signer, dispatcher, proxies),
+		unsubscribe.NewUnsubscribeHandlers(sqlStorage, sqlStorage, sqlStorage, sqlStorage, sqlStorage, signer),
+		preferences.NewPreferenceHandlers(sqlStorage, sqlStorage, signer),
+		confirmHandlers,
 		opts.ServerPort,
 	)
 
@@ -102,6 +214,26 @@ func main() {
 		}
 	}()
 
+	if opts.InboundAddr != "" {
+		if returnPaths == nil {
+			log.Fatal("Bounce domain is required for the inbound server")
+		}
+
+		inboundServer := inbound.NewServer(
+			opts.InboundAddr, opts.BounceDomain, opts.ComplaintsAddress, returnPaths, sqlStorage, sqlStorage, sqlStorage, sqlStorage, complaints,
+		)
+		go func() {
+			if err := inboundServer.ListenAndServe(); err != nil {
+				log.Printf("Inbound server stopped: %v", err)
+			}
+		}()
+		defer func() {
+			if err := inboundServer.Close(); err != nil {
+				log.Printf("Can't close inbound server: %v", err)
+			}
+		}()
+	}
+
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
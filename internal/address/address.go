@@ -0,0 +1,143 @@
+package address
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	maxLength       = 254
+	maxLocalLength  = 64
+	maxDomainLength = 253
+	maxLabelLength  = 63
+)
+
+var ErrInvalid = errors.New("must be a valid email address")
+
+// Normalize trims the address, lowercases it and converts an international
+// domain to its ASCII form. The local part is lowercased too, while RFC 5321
+// allows it to be case sensitive practically no mailbox provider treats it so.
+// Addresses which can't be normalized are returned trimmed for Validate to
+// reject.
+func Normalize(address string) string {
+	address = strings.TrimSpace(address)
+
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return address
+	}
+
+	domain, err := toASCII(address[at+1:])
+	if err != nil {
+		return address
+	}
+	return strings.ToLower(address[:at]) + "@" + domain
+}
+
+// Domain returns the part of the address after the last @.
+func Domain(address string) string {
+	return address[strings.LastIndexByte(address, '@')+1:]
+}
+
+// Validate checks the address against the RFC 5321 mailbox syntax, the domain
+// has to be in its ASCII form and address literals are not accepted. It can be
+// used as an ozzo-validation rule, empty values are left to validation.Required.
+func Validate(value interface{}) error {
+	address, _ := value.(string)
+	if address == "" {
+		return nil
+	}
+	if len(address) > maxLength {
+		return ErrInvalid
+	}
+
+	at := strings.LastIndexByte(address, '@')
+	if at < 0 {
+		return ErrInvalid
+	}
+	if !validLocal(address[:at]) || !validDomain(address[at+1:]) {
+		return ErrInvalid
+	}
+	return nil
+}
+
+func validLocal(local string) bool {
+	if local == "" || len(local) > maxLocalLength {
+		return false
+	}
+
+	if strings.HasPrefix(local, `"`) {
+		return validQuoted(local)
+	}
+
+	for _, atom := range strings.Split(local, ".") {
+		if atom == "" {
+			return false
+		}
+		for i := 0; i < len(atom); i++ {
+			if !isAtext(atom[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// validQuoted checks a quoted-string local part, e.g. "john doe".
+func validQuoted(local string) bool {
+	if len(local) < 2 || !strings.HasSuffix(local, `"`) {
+		return false
+	}
+
+	content := local[1 : len(local)-1]
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '\\':
+			i++
+			if i == len(content) || content[i] < 32 || content[i] > 126 {
+				return false
+			}
+		case c == '"' || c < 32 || c > 126:
+			return false
+		}
+	}
+	return true
+}
+
+func validDomain(domain string) bool {
+	if len(domain) > maxDomainLength {
+		return false
+	}
+
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return false
+	}
+
+	for _, label := range labels {
+		if label == "" || len(label) > maxLabelLength {
+			return false
+		}
+		if label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for i := 0; i < len(label); i++ {
+			if !isLetDig(label[i]) && label[i] != '-' {
+				return false
+			}
+		}
+	}
+
+	// A top level domain is never numeric, it would be an IP address.
+	tld := labels[len(labels)-1]
+	return strings.Trim(tld, "0123456789") != ""
+}
+
+func isLetDig(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func isAtext(c byte) bool {
+	return isLetDig(c) || strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0
+}
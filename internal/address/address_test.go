@@ -0,0 +1,114 @@
+package address
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{in: "  John.Doe@Example.COM ", want: "john.doe@example.com"},
+		{in: "user@münchen.de", want: "user@xn--mnchen-3ya.de"},
+		{in: "user@Bücher.example.", want: "user@xn--bcher-kva.example"},
+		// A decomposed ü is composed before the encoding.
+		{in: "user@mu\u0308nchen.de", want: "user@xn--mnchen-3ya.de"},
+		{in: "user@例え。テスト", want: "user@xn--r8jz45g.xn--zckzah"},
+		{in: "user@ＥＸＡＭＰＬＥ．com", want: "user@example.com"},
+		{in: "user@faß.de", want: "user@xn--fa-hia.de"},
+		{in: "user@xn--mnchen-3ya.de", want: "user@xn--mnchen-3ya.de"},
+		{in: "a@b@example.com", want: "a@b@example.com"},
+		{in: "no-at-sign", want: "no-at-sign"},
+		// Domains IDNA rejects are left for Validate.
+		{in: "User@exa mple.com", want: "User@exa mple.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := Normalize(tt.in); got != tt.want {
+				t.Errorf("Normalize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		in    string
+		valid bool
+	}{
+		{in: "", valid: true},
+		{in: "user@example.com", valid: true},
+		{in: "first.last+tag@sub.example.co.uk", valid: true},
+		{in: "!#$%&'*+-/=?^_`{|}~@example.com", valid: true},
+		{in: `"john doe"@example.com`, valid: true},
+		{in: `"a\"b"@example.com`, valid: true},
+		{in: "user@xn--mnchen-3ya.de", valid: true},
+		{in: "user@123.example", valid: true},
+		{in: "user@münchen.de", valid: false},
+		{in: "user", valid: false},
+		{in: "@example.com", valid: false},
+		{in: "user@", valid: false},
+		{in: "user@localhost", valid: false},
+		{in: "user@127.0.0.1", valid: false},
+		{in: "user@[127.0.0.1]", valid: false},
+		{in: ".user@example.com", valid: false},
+		{in: "us..er@example.com", valid: false},
+		{in: "us er@example.com", valid: false},
+		{in: `"unterminated@example.com`, valid: false},
+		{in: "user@-example.com", valid: false},
+		{in: "user@example-.com", valid: false},
+		{in: "user@exa_mple.com", valid: false},
+		{in: "user@example..com", valid: false},
+		{in: strings.Repeat("a", maxLocalLength+1) + "@example.com", valid: false},
+		{in: "user@" + strings.Repeat("a", maxLabelLength+1) + ".com", valid: false},
+		{in: "user@" + strings.Repeat("a.", maxLength/2) + "com", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if err := Validate(tt.in); (err == nil) != tt.valid {
+				t.Errorf("Validate(%q) = %v, want valid %v", tt.in, err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	content := "# disposable\n\nMailinator.com\nwegwerf-müll.de\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	blocklist, err := LoadBlocklist(path)
+	if err != nil {
+		t.Fatalf("LoadBlocklist error: %v", err)
+	}
+
+	tests := []struct {
+		address string
+		blocked bool
+	}{
+		{address: "a@mailinator.com", blocked: true},
+		{address: "a@eu.mailinator.com", blocked: true},
+		{address: Normalize("a@wegwerf-müll.de"), blocked: true},
+		{address: "a@notmailinator.com", blocked: false},
+		{address: "a@example.com", blocked: false},
+	}
+
+	for _, tt := range tests {
+		if got := blocklist.Blocked(tt.address); got != tt.blocked {
+			t.Errorf("Blocked(%q) = %v, want %v", tt.address, got, tt.blocked)
+		}
+	}
+
+	var empty Blocklist
+	if empty.Blocked("a@mailinator.com") {
+		t.Error("nil Blocklist blocks")
+	}
+}
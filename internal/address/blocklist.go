@@ -0,0 +1,59 @@
+package address
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Blocklist is a set of domains, e.g. of disposable mailbox providers. A nil
+// Blocklist blocks nothing.
+type Blocklist map[string]struct{}
+
+// LoadBlocklist reads a file with a domain per line, empty lines and lines
+// starting with # are skipped.
+func LoadBlocklist(path string) (Blocklist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can't open blocklist: %w", err)
+	}
+	defer f.Close()
+
+	blocklist := Blocklist{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		domain, err := toASCII(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid domain %q: %w", line, err)
+		}
+		blocklist[domain] = struct{}{}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("can't read blocklist: %w", err)
+	}
+
+	return blocklist, nil
+}
+
+// Blocked reports whether the domain of the normalized address or any of its
+// parent domains is on the list.
+func (b Blocklist) Blocked(address string) bool {
+	domain := Domain(address)
+	for {
+		if _, ok := b[domain]; ok {
+			return true
+		}
+
+		dot := strings.IndexByte(domain, '.')
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
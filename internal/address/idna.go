@@ -0,0 +1,17 @@
+package address
+
+import (
+	"golang.org/x/net/idna"
+	"strings"
+)
+
+// toASCII converts the domain to its ASCII form with the IDNA lookup profile,
+// which maps it per UTS 46 (case folding, NFC, full width forms and full
+// stops) before encoding the labels to Punycode.
+func toASCII(domain string) (string, error) {
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(ascii, "."), nil
+}
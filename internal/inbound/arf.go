@@ -0,0 +1,68 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// Feedback holds the fields of an abuse feedback report (RFC 5965) we need
+// to find the mail it complains about.
+type Feedback struct {
+	FeedbackType     string
+	UserAgent        string
+	OriginalMailFrom string
+	// ReturnPath is taken from the headers of the embedded original message.
+	ReturnPath string
+}
+
+// ParseARF reads a multipart/report message with the feedback-report report
+// type.
+func ParseARF(r io.Reader) (Feedback, error) {
+	parts, err := reportParts(r, "feedback-report")
+	if err != nil {
+		return Feedback{}, err
+	}
+
+	report, ok := parts["message/feedback-report"]
+	if !ok {
+		return Feedback{}, ErrNotReport
+	}
+
+	fields, err := readHeader(report)
+	if err != nil {
+		return Feedback{}, fmt.Errorf("can't read feedback report: %w", err)
+	}
+
+	feedback := Feedback{
+		FeedbackType:     strings.ToLower(strings.TrimSpace(fields.Get("Feedback-Type"))),
+		UserAgent:        strings.TrimSpace(fields.Get("User-Agent")),
+		OriginalMailFrom: addressField(fields.Get("Original-Mail-From")),
+	}
+
+	original, ok := parts["message/rfc822"]
+	if !ok {
+		original, ok = parts["text/rfc822-headers"]
+	}
+	if ok {
+		headers, err := readHeader(original)
+		if err != nil {
+			return Feedback{}, fmt.Errorf("can't read original message: %w", err)
+		}
+		feedback.ReturnPath = addressField(headers.Get("Return-Path"))
+	}
+
+	return feedback, nil
+}
+
+func readHeader(b []byte) (textproto.MIMEHeader, error) {
+	header, err := textproto.NewReader(bufio.NewReader(bytes.NewReader(b))).ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return header, nil
+}
@@ -0,0 +1,173 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/verp"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseARF(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    Feedback
+		wantErr error
+	}{
+		{
+			name: "full report",
+			message: report("feedback-report",
+				"text/plain", "This is an abuse report.",
+				"message/feedback-report",
+				"Feedback-Type: Abuse\r\nUser-Agent: FBL/1.0\r\nVersion: 1\r\nOriginal-Mail-From: <bounce+x.y@bounces.example.com>",
+				"message/rfc822",
+				"Return-Path: <bounce+a.b@bounces.example.com>\r\nFrom: news@example.com\r\n\r\nHello",
+			),
+			want: Feedback{
+				FeedbackType:     "abuse",
+				UserAgent:        "FBL/1.0",
+				OriginalMailFrom: "bounce+x.y@bounces.example.com",
+				ReturnPath:       "bounce+a.b@bounces.example.com",
+			},
+		},
+		{
+			name: "headers only",
+			message: report("feedback-report",
+				"message/feedback-report", "Feedback-Type: fraud",
+				"text/rfc822-headers", "Return-Path: <bounce+a.b@bounces.example.com>",
+			),
+			want: Feedback{FeedbackType: "fraud", ReturnPath: "bounce+a.b@bounces.example.com"},
+		},
+		{
+			name:    "without the feedback part",
+			message: report("feedback-report", "text/plain", "This is an abuse report."),
+			wantErr: ErrNotReport,
+		},
+		{
+			name:    "delivery status report",
+			message: report("delivery-status", "message/feedback-report", "Feedback-Type: abuse"),
+			wantErr: ErrNotReport,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseARF(strings.NewReader(tt.message))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseARF error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseARF = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+type fakeComplaints struct {
+	complaints []model.Complaint
+}
+
+func (f *fakeComplaints) CreateComplaint(_ context.Context, complaint model.Complaint) error {
+	f.complaints = append(f.complaints, complaint)
+	return nil
+}
+
+type fakePreferences struct {
+	storage.Preferences
+	unsubscribed []uuid.UUID
+	sources      []string
+}
+
+func (f *fakePreferences) SetGroupSubscription(_ context.Context, _, groupID uuid.UUID, subscribed bool, source string) error {
+	if !subscribed {
+		f.unsubscribed = append(f.unsubscribed, groupID)
+		f.sources = append(f.sources, source)
+	}
+	return nil
+}
+
+func TestComplaintsProcess(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	returnPaths := verp.NewEncoder("bounces.example.com", signer)
+
+	user := model.User{ID: uuid.New(), Email: "user@example.com"}
+	groupID := uuid.New()
+	mail := model.Mail{ID: uuid.New(), ToUserId: user.ID, GroupId: uuid.NullUUID{UUID: groupID, Valid: true}}
+	forged := "bounce+" + mail.ID.String() + ".0000000000000000@bounces.example.com"
+
+	tests := []struct {
+		name         string
+		returnPaths  *verp.Encoder
+		mailFrom     string
+		returnPath   string
+		feedbackType string
+		wantErr      error
+		wantType     string
+	}{
+		{name: "original mail from", returnPaths: returnPaths, mailFrom: returnPaths.Address(mail.ID), feedbackType: "abuse", wantType: "abuse"},
+		{name: "return path of the original", returnPaths: returnPaths, returnPath: returnPaths.Address(mail.ID), wantType: "abuse"},
+		{name: "forged signature", returnPaths: returnPaths, mailFrom: forged, feedbackType: "abuse", wantErr: ErrUnknownMail},
+		{name: "forged mail from, valid return path", returnPaths: returnPaths, mailFrom: forged, returnPath: returnPaths.Address(mail.ID), feedbackType: "virus", wantType: "virus"},
+		{name: "without return paths", mailFrom: returnPaths.Address(mail.ID), feedbackType: "abuse", wantErr: ErrUnknownMail},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			complaints := &fakeComplaints{}
+			preferences := &fakePreferences{}
+			suppressions := &fakeSuppressions{}
+			c := NewComplaints(
+				tt.returnPaths,
+				complaints,
+				&fakeMails{mails: map[uuid.UUID]model.Mail{mail.ID: mail}},
+				&fakeUsers{users: map[uuid.UUID]model.User{user.ID: user}},
+				preferences,
+				suppressions,
+			)
+
+			fields := "Version: 1"
+			if tt.feedbackType != "" {
+				fields += "\r\nFeedback-Type: " + tt.feedbackType
+			}
+			if tt.mailFrom != "" {
+				fields += "\r\nOriginal-Mail-From: <" + tt.mailFrom + ">"
+			}
+			parts := []string{"message/feedback-report", fields}
+			if tt.returnPath != "" {
+				parts = append(parts, "text/rfc822-headers", "Return-Path: <"+tt.returnPath+">")
+			}
+
+			err := c.Process(context.Background(), strings.NewReader(report("feedback-report", parts...)))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Process error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(complaints.complaints) != 0 || len(suppressions.addresses) != 0 || len(preferences.unsubscribed) != 0 {
+					t.Errorf("complaint was processed for an unknown mail")
+				}
+				return
+			}
+
+			want := []model.Complaint{{MailID: mail.ID, FeedbackType: tt.wantType}}
+			if !reflect.DeepEqual(complaints.complaints, want) {
+				t.Errorf("complaints = %+v, want %+v", complaints.complaints, want)
+			}
+			if !reflect.DeepEqual(suppressions.addresses, []string{user.Email}) {
+				t.Errorf("suppressions = %v, want %v", suppressions.addresses, []string{user.Email})
+			}
+			if !reflect.DeepEqual(preferences.unsubscribed, []uuid.UUID{groupID}) || !reflect.DeepEqual(preferences.sources, []string{"arf"}) {
+				t.Errorf("unsubscribed = %v from %v, want %v from arf", preferences.unsubscribed, preferences.sources, groupID)
+			}
+		})
+	}
+}
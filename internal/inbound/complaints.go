@@ -0,0 +1,112 @@
+package inbound
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/verp"
+)
+
+var ErrUnknownMail = errors.New("report doesn't belong to any mail")
+
+// Complaints records feedback reports and stops sending mails to the users
+// who complained.
+type Complaints struct {
+	returnPaths  *verp.Encoder
+	complaints   storage.Complaint
+	mails        storage.Mail
+	users        storage.User
+	preferences  storage.Preferences
+	suppressions storage.Suppression
+}
+
+func NewComplaints(returnPaths *verp.Encoder, complaints storage.Complaint, mails storage.Mail, users storage.User, preferences storage.Preferences, suppressions storage.Suppression) *Complaints {
+	return &Complaints{
+		returnPaths:  returnPaths,
+		complaints:   complaints,
+		mails:        mails,
+		users:        users,
+		preferences:  preferences,
+		suppressions: suppressions,
+	}
+}
+
+// Process parses the ARF report, records the complaint for the mail found by
+// its return path, suppresses the recipient and unsubscribes them from the
+// group the mail was sent to.
+func (c *Complaints) Process(ctx context.Context, r io.Reader) error {
+	feedback, err := ParseARF(r)
+	if err != nil {
+		return err
+	}
+
+	mailId, err := c.mailId(feedback)
+	if err != nil {
+		return err
+	}
+
+	mail, err := c.mails.GetMailById(ctx, mailId)
+	if err != nil {
+		return fmt.Errorf("can't get mail: %w", err)
+	}
+
+	user, err := c.users.GetUser(ctx, mail.ToUserId)
+	if err != nil {
+		return fmt.Errorf("can't get user: %w", err)
+	}
+
+	feedbackType := feedback.FeedbackType
+	if feedbackType == "" {
+		feedbackType = "abuse"
+	}
+
+	err = c.complaints.CreateComplaint(ctx, model.Complaint{
+		MailID:       mail.ID,
+		FeedbackType: feedbackType,
+		UserAgent:    feedback.UserAgent,
+	})
+	if err != nil {
+		return fmt.Errorf("can't create complaint: %w", err)
+	}
+
+	err = c.suppressions.AddSuppression(ctx, model.Suppression{
+		Address: user.Email,
+		Reason:  model.SuppressionComplaint,
+		Source:  "arf",
+	})
+	if err != nil {
+		return fmt.Errorf("can't add suppression: %w", err)
+	}
+
+	if mail.GroupId.Valid {
+		err = c.preferences.SetGroupSubscription(ctx, user.ID, mail.GroupId.UUID, false, "arf")
+		if err != nil {
+			return fmt.Errorf("can't unsubscribe from group: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// mailId finds the mail by the signed return path either reported by the
+// mailbox provider or left in the headers of the original message. Without
+// return paths no mail can be found.
+func (c *Complaints) mailId(feedback Feedback) (uuid.UUID, error) {
+	if c.returnPaths == nil {
+		return uuid.Nil, ErrUnknownMail
+	}
+
+	for _, address := range []string{feedback.OriginalMailFrom, feedback.ReturnPath} {
+		if address == "" {
+			continue
+		}
+		if id, err := c.returnPaths.Parse(address); err == nil {
+			return id, nil
+		}
+	}
+	return uuid.Nil, ErrUnknownMail
+}
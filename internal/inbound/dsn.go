@@ -0,0 +1,72 @@
+package inbound
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+)
+
+// Recipient holds the per-recipient fields of a delivery status
+// notification (RFC 3464).
+type Recipient struct {
+	FinalRecipient string
+	Action         string
+	Status         string
+	DiagnosticCode string
+}
+
+// Failed reports whether the delivery to the recipient was given up or delayed.
+func (r Recipient) Failed() bool {
+	return r.Action == "failed" || r.Action == "delayed"
+}
+
+// Permanent reports whether the failure is a hard bounce.
+func (r Recipient) Permanent() bool {
+	return r.Action == "failed" && strings.HasPrefix(r.Status, "5.")
+}
+
+// ParseDSN reads a multipart/report message with the delivery-status report
+// type and returns its recipients.
+func ParseDSN(r io.Reader) ([]Recipient, error) {
+	parts, err := reportParts(r, "delivery-status")
+	if err != nil {
+		return nil, err
+	}
+
+	status, ok := parts["message/delivery-status"]
+	if !ok {
+		return nil, ErrNotReport
+	}
+
+	tp := textproto.NewReader(bufio.NewReader(bytes.NewReader(status)))
+
+	// The first block holds per-message fields which we don't need.
+	if _, err = tp.ReadMIMEHeader(); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("can't read per-message fields: %w", err)
+	}
+
+	var recipients []Recipient
+	for {
+		fields, err := tp.ReadMIMEHeader()
+		if len(fields) > 0 {
+			recipients = append(recipients, Recipient{
+				FinalRecipient: addressField(fields.Get("Final-Recipient")),
+				Action:         strings.ToLower(strings.TrimSpace(fields.Get("Action"))),
+				Status:         strings.TrimSpace(fields.Get("Status")),
+				DiagnosticCode: typedField(fields.Get("Diagnostic-Code")),
+			})
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read per-recipient fields: %w", err)
+		}
+	}
+
+	return recipients, nil
+}
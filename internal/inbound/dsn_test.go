@@ -0,0 +1,110 @@
+package inbound
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// report builds a multipart/report message with the given report type and
+// parts, each part is a content type and a body.
+func report(reportType string, parts ...string) string {
+	var b strings.Builder
+	b.WriteString("From: MAILER-DAEMON@mx.example\r\n")
+	b.WriteString("Content-Type: multipart/report; report-type=" + reportType + "; boundary=b\r\n\r\n")
+	for i := 0; i+1 < len(parts); i += 2 {
+		b.WriteString("--b\r\nContent-Type: " + parts[i] + "\r\n\r\n" + parts[i+1] + "\r\n")
+	}
+	b.WriteString("--b--\r\n")
+	return b.String()
+}
+
+func TestParseDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		message string
+		want    []Recipient
+		wantErr error
+	}{
+		{
+			name: "hard bounce",
+			message: report("delivery-status",
+				"text/plain", "Delivery failed.",
+				"message/delivery-status",
+				"Reporting-MTA: dns; mx.example\r\n\r\n"+
+					"Final-Recipient: rfc822; <user@example.com>\r\n"+
+					"Action: Failed\r\n"+
+					"Status: 5.1.1\r\n"+
+					"Diagnostic-Code: smtp; 550 5.1.1 No such user",
+			),
+			want: []Recipient{{
+				FinalRecipient: "user@example.com",
+				Action:         "failed",
+				Status:         "5.1.1",
+				DiagnosticCode: "550 5.1.1 No such user",
+			}},
+		},
+		{
+			name: "several recipients",
+			message: report("Delivery-Status",
+				"message/delivery-status",
+				"Reporting-MTA: dns; mx.example\r\n\r\n"+
+					"Final-Recipient: rfc822; a@example.com\r\nAction: delayed\r\nStatus: 4.2.2\r\n\r\n"+
+					"Final-Recipient: rfc822; b@example.com\r\nAction: delivered\r\nStatus: 2.0.0",
+			),
+			want: []Recipient{
+				{FinalRecipient: "a@example.com", Action: "delayed", Status: "4.2.2"},
+				{FinalRecipient: "b@example.com", Action: "delivered", Status: "2.0.0"},
+			},
+		},
+		{
+			name:    "other report type",
+			message: report("feedback-report", "message/delivery-status", "Reporting-MTA: dns; mx.example"),
+			wantErr: ErrNotReport,
+		},
+		{
+			name:    "without a status part",
+			message: report("delivery-status", "text/plain", "Delivery failed."),
+			wantErr: ErrNotReport,
+		},
+		{
+			name:    "plain message",
+			message: "From: a@example.com\r\nContent-Type: text/plain\r\n\r\nHello\r\n",
+			wantErr: ErrNotReport,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseDSN(strings.NewReader(tt.message))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ParseDSN error = %v, want %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseDSN = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipientBounceType(t *testing.T) {
+	tests := []struct {
+		action, status    string
+		failed, permanent bool
+	}{
+		{action: "failed", status: "5.1.1", failed: true, permanent: true},
+		{action: "failed", status: "4.4.7", failed: true, permanent: false},
+		{action: "delayed", status: "4.2.2", failed: true, permanent: false},
+		{action: "delivered", status: "2.0.0", failed: false, permanent: false},
+		{action: "relayed", status: "2.0.0", failed: false, permanent: false},
+	}
+
+	for _, tt := range tests {
+		r := Recipient{Action: tt.action, Status: tt.status}
+		if r.Failed() != tt.failed || r.Permanent() != tt.permanent {
+			t.Errorf("%s %s: Failed = %v, Permanent = %v, want %v, %v",
+				tt.action, tt.status, r.Failed(), r.Permanent(), tt.failed, tt.permanent)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package inbound
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"strings"
+)
+
+var ErrNotReport = errors.New("message is not a report")
+
+// reportParts reads a multipart/report message of the reportType and returns
+// the bodies of its parts by content type.
+func reportParts(r io.Reader, reportType string) (map[string][]byte, error) {
+	msg, err := mail.ReadMessage(r)
+	if err != nil {
+		return nil, fmt.Errorf("can't read message: %w", err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, ErrNotReport
+	}
+	if mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], reportType) {
+		return nil, ErrNotReport
+	}
+
+	parts := make(map[string][]byte)
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return parts, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read part: %w", err)
+		}
+
+		t, _, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			continue
+		}
+		if _, ok := parts[t]; ok {
+			continue
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return nil, fmt.Errorf("can't read part: %w", err)
+		}
+		parts[t] = body
+	}
+}
+
+// typedField strips the type prefix of fields like "rfc822; user@example.com".
+func typedField(v string) string {
+	if _, value, ok := strings.Cut(v, ";"); ok {
+		return strings.TrimSpace(value)
+	}
+	return strings.TrimSpace(v)
+}
+
+func addressField(v string) string {
+	return strings.Trim(typedField(v), "<>")
+}
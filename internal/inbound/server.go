@@ -0,0 +1,189 @@
+package inbound
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/emersion/go-smtp"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"mail-service/internal/address"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/verp"
+	"strings"
+	"time"
+)
+
+const maxMessageBytes = 10 << 20
+
+// NewServer creates an SMTP server which accepts bounces sent to the return
+// paths of our mails and feedback reports sent to the complaints address,
+// the complaints address is disabled if it is empty.
+func NewServer(addr, domain, complaintsAddress string, returnPaths *verp.Encoder, mails storage.Mail, users storage.User, bounces storage.Bounce, suppressions storage.Suppression, complaints *Complaints) *smtp.Server {
+	s := smtp.NewServer(&backend{
+		complaintsAddress: strings.ToLower(complaintsAddress),
+		returnPaths:       returnPaths,
+		mails:             mails,
+		users:             users,
+		bounces:           bounces,
+		suppressions:      suppressions,
+		complaints:        complaints,
+	})
+	s.Addr = addr
+	s.Domain = domain
+	s.MaxMessageBytes = maxMessageBytes
+	s.ReadTimeout = time.Minute
+	s.WriteTimeout = time.Minute
+	s.AuthDisabled = true
+	return s
+}
+
+type backend struct {
+	complaintsAddress string
+	returnPaths       *verp.Encoder
+	mails             storage.Mail
+	users             storage.User
+	bounces           storage.Bounce
+	suppressions      storage.Suppression
+	complaints        *Complaints
+}
+
+func (b *backend) Login(_ *smtp.ConnectionState, _, _ string) (smtp.Session, error) {
+	return nil, smtp.ErrAuthUnsupported
+}
+
+func (b *backend) AnonymousLogin(_ *smtp.ConnectionState) (smtp.Session, error) {
+	return &session{backend: b}, nil
+}
+
+type session struct {
+	backend *backend
+	mailIds []uuid.UUID
+}
+
+func (s *session) Reset() {
+	s.mailIds = nil
+}
+
+func (s *session) Logout() error {
+	return nil
+}
+
+func (s *session) Mail(_ string, _ smtp.MailOptions) error {
+	return nil
+}
+
+// Rcpt accepts only the return paths of the mails we sent and the
+// complaints address.
+func (s *session) Rcpt(to string) error {
+	address := strings.ToLower(strings.Trim(to, "<>"))
+	if s.backend.complaintsAddress != "" && address == s.backend.complaintsAddress {
+		return nil
+	}
+
+	id, err := s.backend.returnPaths.Parse(to)
+	if err != nil {
+		return &smtp.SMTPError{
+			Code:         550,
+			EnhancedCode: smtp.EnhancedCode{5, 1, 1},
+			Message:      "No such recipient",
+		}
+	}
+	s.mailIds = append(s.mailIds, id)
+	return nil
+}
+
+func (s *session) Data(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("can't read message: %w", err)
+	}
+
+	// Providers send feedback reports to the return path as well, so both
+	// kinds of reports are accepted on every address.
+	err = s.backend.complaints.Process(context.Background(), bytes.NewReader(data))
+	if err == nil {
+		return nil
+	} else if !errors.Is(err, ErrNotReport) {
+		log.Printf("can't process complaint: %v", err)
+		return nil
+	}
+
+	recipients, err := ParseDSN(bytes.NewReader(data))
+	if errors.Is(err, ErrNotReport) {
+		return nil
+	}
+	if err != nil {
+		log.Printf("can't parse delivery status notification: %v", err)
+		return nil
+	}
+
+	for _, mailId := range s.mailIds {
+		email, err := s.recipient(mailId)
+		if err != nil {
+			log.Printf("can't get bounced mail recipient: %v", err)
+			continue
+		}
+
+		for _, recipient := range recipients {
+			if !recipient.Failed() {
+				continue
+			}
+
+			// The report is written by whoever sends it, only the recipient
+			// of the mail the return path was issued for can be suppressed.
+			if address.Normalize(recipient.FinalRecipient) != email {
+				log.Printf("bounce of mail %s for %q doesn't match its recipient", mailId, recipient.FinalRecipient)
+				continue
+			}
+
+			bounceType := model.BounceSoft
+			if recipient.Permanent() {
+				bounceType = model.BounceHard
+			}
+
+			err = s.backend.bounces.CreateBounce(context.Background(), model.Bounce{
+				MailID:         mailId,
+				Recipient:      email,
+				BounceType:     bounceType,
+				Status:         recipient.Status,
+				DiagnosticCode: recipient.DiagnosticCode,
+			})
+			if err != nil {
+				log.Printf("can't record bounce: %v", err)
+				continue
+			}
+
+			if bounceType != model.BounceHard {
+				continue
+			}
+			err = s.backend.suppressions.AddSuppression(context.Background(), model.Suppression{
+				Address: email,
+				Reason:  model.SuppressionBounce,
+				Source:  "dsn",
+			})
+			if err != nil {
+				log.Printf("can't add suppression: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// recipient returns the address the mail was sent to.
+func (s *session) recipient(mailId uuid.UUID) (string, error) {
+	mail, err := s.backend.mails.GetMailById(context.Background(), mailId)
+	if err != nil {
+		return "", fmt.Errorf("can't get mail: %w", err)
+	}
+
+	user, err := s.backend.users.GetUser(context.Background(), mail.ToUserId)
+	if err != nil {
+		return "", fmt.Errorf("can't get user: %w", err)
+	}
+
+	return user.Email, nil
+}
@@ -0,0 +1,134 @@
+package inbound
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeMails struct {
+	storage.Mail
+	mails map[uuid.UUID]model.Mail
+}
+
+func (f *fakeMails) GetMailById(_ context.Context, id uuid.UUID) (model.Mail, error) {
+	mail, ok := f.mails[id]
+	if !ok {
+		return model.Mail{}, sql.ErrNoRows
+	}
+	return mail, nil
+}
+
+type fakeUsers struct {
+	storage.User
+	users map[uuid.UUID]model.User
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, id uuid.UUID) (model.User, error) {
+	user, ok := f.users[id]
+	if !ok {
+		return model.User{}, sql.ErrNoRows
+	}
+	return user, nil
+}
+
+type fakeBounces struct {
+	bounces []model.Bounce
+}
+
+func (f *fakeBounces) CreateBounce(_ context.Context, bounce model.Bounce) error {
+	f.bounces = append(f.bounces, bounce)
+	return nil
+}
+
+type fakeSuppressions struct {
+	storage.Suppression
+	addresses []string
+}
+
+func (f *fakeSuppressions) AddSuppression(_ context.Context, suppression model.Suppression) error {
+	f.addresses = append(f.addresses, suppression.Address)
+	return nil
+}
+
+const dsnTemplate = "From: MAILER-DAEMON@mx.example\r\n" +
+	"Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n" +
+	"\r\n" +
+	"--b\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"Delivery failed.\r\n" +
+	"--b\r\n" +
+	"Content-Type: message/delivery-status\r\n" +
+	"\r\n" +
+	"Reporting-MTA: dns; mx.example\r\n" +
+	"\r\n" +
+	"Final-Recipient: rfc822; {recipient}\r\n" +
+	"Action: failed\r\n" +
+	"Status: 5.1.1\r\n" +
+	"Diagnostic-Code: smtp; 550 5.1.1 No such user\r\n" +
+	"--b--\r\n"
+
+func TestSessionDataBounces(t *testing.T) {
+	user := model.User{ID: uuid.New(), Email: "user@example.com"}
+	mail := model.Mail{ID: uuid.New(), ToUserId: user.ID}
+
+	tests := []struct {
+		name       string
+		recipient  string
+		wantBounce bool
+	}{
+		{name: "mail recipient", recipient: "user@example.com", wantBounce: true},
+		{name: "recipient in another case", recipient: "User@Example.COM", wantBounce: true},
+		{name: "another address", recipient: "victim@example.com", wantBounce: false},
+		{name: "no address", recipient: "", wantBounce: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounces := &fakeBounces{}
+			suppressions := &fakeSuppressions{}
+			s := &session{
+				backend: &backend{
+					mails:        &fakeMails{mails: map[uuid.UUID]model.Mail{mail.ID: mail}},
+					users:        &fakeUsers{users: map[uuid.UUID]model.User{user.ID: user}},
+					bounces:      bounces,
+					suppressions: suppressions,
+					complaints:   &Complaints{},
+				},
+				mailIds: []uuid.UUID{mail.ID},
+			}
+
+			dsn := strings.ReplaceAll(dsnTemplate, "{recipient}", tt.recipient)
+			if err := s.Data(strings.NewReader(dsn)); err != nil {
+				t.Fatalf("Data error: %v", err)
+			}
+
+			if !tt.wantBounce {
+				if len(bounces.bounces) != 0 || len(suppressions.addresses) != 0 {
+					t.Errorf("bounces = %v, suppressions = %v, want none", bounces.bounces, suppressions.addresses)
+				}
+				return
+			}
+
+			want := []model.Bounce{{
+				MailID:         mail.ID,
+				Recipient:      user.Email,
+				BounceType:     model.BounceHard,
+				Status:         "5.1.1",
+				DiagnosticCode: "550 5.1.1 No such user",
+			}}
+			if !reflect.DeepEqual(bounces.bounces, want) {
+				t.Errorf("bounces = %+v, want %+v", bounces.bounces, want)
+			}
+			if !reflect.DeepEqual(suppressions.addresses, []string{user.Email}) {
+				t.Errorf("suppressions = %v, want %v", suppressions.addresses, []string{user.Email})
+			}
+		})
+	}
+}
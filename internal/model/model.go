@@ -1,40 +1,524 @@
 package model
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	validation "github.com/go-ozzo/ozzo-validation"
+	"github.com/go-ozzo/ozzo-validation/is"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"mail-service/internal/address"
+	"mail-service/internal/schedule"
+	"mail-service/internal/segment"
+	"regexp"
+	"strings"
 	"time"
 )
 
+const (
+	MailStatusPending    = "pending"
+	MailStatusSent       = "sent"
+	MailStatusFailed     = "failed"
+	MailStatusBounced    = "bounced"
+	MailStatusSuppressed = "suppressed"
+	MailStatusDeferred   = "deferred"
+	MailStatusCapped     = "capped"
+)
+
+const (
+	PriorityNormal = "normal"
+	PriorityUrgent = "urgent"
+)
+
+const (
+	BounceHard = "hard"
+	BounceSoft = "soft"
+)
+
+const (
+	SuppressionBounce      = "bounce"
+	SuppressionComplaint   = "complaint"
+	SuppressionUnsubscribe = "unsubscribe"
+	SuppressionManual      = "manual"
+	SuppressionErasure     = "erasure"
+)
+
+const (
+	DefaultTemplate = "template"
+	ConfirmTemplate = "confirm"
+)
+
+const (
+	EventMailSent    = "mail.sent"
+	EventMailFailed  = "mail.failed"
+	EventMailOpened  = "mail.opened"
+	EventMailClicked = "mail.clicked"
+)
+
+var webhookEvents = []interface{}{EventMailSent, EventMailFailed, EventMailOpened, EventMailClicked}
+
+const (
+	ImportCreated = "created"
+	ImportUpdated = "updated"
+	ImportInvalid = "invalid"
+)
+
+const (
+	MembershipAdded     = "added"
+	MembershipExists    = "exists"
+	MembershipRemoved   = "removed"
+	MembershipNotMember = "not_member"
+	MembershipNotFound  = "not_found"
+)
+
+// MaxMembershipChange is the max number of users in one bulk membership
+// change.
+const MaxMembershipChange = 10000
+
+const (
+	AttributeString  = "string"
+	AttributeNumber  = "number"
+	AttributeBoolean = "boolean"
+)
+
+var templateNameRe = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// attributeNameRe keeps attribute names usable as template fields.
+var attributeNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
 type User struct {
-	ID        uuid.UUID `json:"id" db:"id"`
-	Email     string    `json:"email" db:"email"`
-	FirstName string    `json:"first_name" db:"first_name"`
-	LastName  string    `json:"last_name" db:"last_name"`
-	CreatedAt string    `json:"created_at" db:"created_at"`
+	ID         uuid.UUID  `json:"id" db:"id"`
+	Email      string     `json:"email" db:"email"`
+	FirstName  string     `json:"first_name" db:"first_name"`
+	LastName   string     `json:"last_name" db:"last_name"`
+	Timezone   string     `json:"timezone" db:"timezone"`
+	Attributes Attributes `json:"attributes" db:"attributes"`
+	CreatedAt  string     `json:"created_at" db:"created_at"`
 }
 
-type Group struct {
-	ID        uuid.UUID `json:"id" db:"id"`
+func (u *User) Validate() error {
+	return validation.ValidateStruct(u,
+		validation.Field(&u.Email, validation.Required, validation.By(address.Validate)),
+		validation.Field(&u.FirstName, validation.Length(0, 255)),
+		validation.Field(&u.LastName, validation.Length(0, 255)),
+		validation.Field(&u.Timezone, validation.Required, validation.By(validateTimezone)),
+	)
+}
+
+func validateTimezone(value interface{}) error {
+	if _, err := time.LoadLocation(value.(string)); err != nil {
+		return errors.New("must be a valid IANA time zone")
+	}
+	return nil
+}
+
+// UserPatch holds the fields of a partial user update, nil fields are left
+// unchanged. Attributes are merged into the existing ones, an attribute set
+// to null is removed.
+type UserPatch struct {
+	Email      *string    `json:"email"`
+	FirstName  *string    `json:"first_name"`
+	LastName   *string    `json:"last_name"`
+	Timezone   *string    `json:"timezone"`
+	Attributes Attributes `json:"attributes"`
+}
+
+func (p *UserPatch) Apply(user *User) {
+	if p.Email != nil {
+		user.Email = *p.Email
+	}
+	if p.FirstName != nil {
+		user.FirstName = *p.FirstName
+	}
+	if p.LastName != nil {
+		user.LastName = *p.LastName
+	}
+	if p.Timezone != nil {
+		user.Timezone = *p.Timezone
+	}
+	if len(p.Attributes) > 0 && user.Attributes == nil {
+		user.Attributes = Attributes{}
+	}
+	for name, value := range p.Attributes {
+		if value == nil {
+			delete(user.Attributes, name)
+			continue
+		}
+		user.Attributes[name] = value
+	}
+}
+
+// Attributes are the custom user fields stored as a JSONB object.
+type Attributes map[string]interface{}
+
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *Attributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	case nil:
+		*a = nil
+		return nil
+	default:
+		return fmt.Errorf("can't scan attributes from %T", src)
+	}
+	return json.Unmarshal(data, a)
+}
+
+// Conform checks the attribute names and the values against the declared
+// definitions and fills in the defaults of the missing ones. Attributes
+// without a definition are kept as is.
+func (a Attributes) Conform(definitions []AttributeDefinition) (Attributes, error) {
+	result, err := a.Check(definitions)
+	if err != nil {
+		return nil, err
+	}
+
+	defaults, err := result.Defaults(definitions)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range defaults {
+		result[name] = value
+	}
+
+	return result, nil
+}
+
+// Check checks the attribute names and the values against the declared
+// definitions, null values are dropped. Unlike Conform it neither fills in
+// defaults nor requires attributes.
+func (a Attributes) Check(definitions []AttributeDefinition) (Attributes, error) {
+	result := Attributes{}
+	for name, value := range a {
+		if !attributeNameRe.MatchString(name) {
+			return nil, fmt.Errorf("invalid attribute name %q", name)
+		}
+		if value != nil {
+			result[name] = value
+		}
+	}
+
+	for _, definition := range definitions {
+		value, ok := result[definition.Name]
+		if ok && !definition.Accepts(value) {
+			return nil, fmt.Errorf("attribute %q must be a %s", definition.Name, definition.Type)
+		}
+	}
+
+	return result, nil
+}
+
+// Defaults returns the default values of the declared attributes missing from
+// a, it fails if a required attribute without a default is missing.
+func (a Attributes) Defaults(definitions []AttributeDefinition) (Attributes, error) {
+	defaults := Attributes{}
+	for _, definition := range definitions {
+		if value, ok := a[definition.Name]; ok && value != nil {
+			continue
+		}
+		if definition.Default.Valid {
+			defaults[definition.Name] = definition.Default.Data
+			continue
+		}
+		if definition.Required {
+			return nil, fmt.Errorf("attribute %q is required", definition.Name)
+		}
+	}
+
+	return defaults, nil
+}
+
+// AttributeDefinition declares the type of a custom user attribute.
+type AttributeDefinition struct {
 	Name      string    `json:"name" db:"name"`
+	Type      string    `json:"type" db:"type"`
+	Required  bool      `json:"required" db:"required"`
+	Default   NullValue `json:"default" db:"default_value"`
 	CreatedAt string    `json:"created_at" db:"created_at"`
 }
 
+func (d *AttributeDefinition) Validate() error {
+	return validation.ValidateStruct(d,
+		validation.Field(&d.Name, validation.Required, validation.Match(attributeNameRe)),
+		validation.Field(&d.Type, validation.Required, validation.In(AttributeString, AttributeNumber, AttributeBoolean)),
+		validation.Field(&d.Default, validation.By(func(interface{}) error {
+			if d.Default.Valid && !d.Accepts(d.Default.Data) {
+				return errors.New("default doesn't match the type")
+			}
+			return nil
+		})),
+	)
+}
+
+// Accepts reports whether the decoded JSON value has the declared type.
+func (d *AttributeDefinition) Accepts(value interface{}) bool {
+	switch value.(type) {
+	case string:
+		return d.Type == AttributeString
+	case float64:
+		return d.Type == AttributeNumber
+	case bool:
+		return d.Type == AttributeBoolean
+	}
+	return false
+}
+
+// NullValue is a nullable JSON value stored in a JSONB column.
+type NullValue struct {
+	Data  interface{}
+	Valid bool
+}
+
+func (v NullValue) MarshalJSON() ([]byte, error) {
+	if !v.Valid {
+		return []byte("null"), nil
+	}
+	return json.Marshal(v.Data)
+}
+
+func (v *NullValue) UnmarshalJSON(data []byte) error {
+	v.Data, v.Valid = nil, false
+	if err := json.Unmarshal(data, &v.Data); err != nil {
+		return err
+	}
+	v.Valid = v.Data != nil
+	return nil
+}
+
+func (v NullValue) Value() (driver.Value, error) {
+	if !v.Valid {
+		return nil, nil
+	}
+	return json.Marshal(v.Data)
+}
+
+func (v *NullValue) Scan(src interface{}) error {
+	v.Data, v.Valid = nil, false
+	switch data := src.(type) {
+	case []byte:
+		return v.UnmarshalJSON(data)
+	case string:
+		return v.UnmarshalJSON([]byte(data))
+	case nil:
+		return nil
+	}
+	return fmt.Errorf("can't scan value from %T", src)
+}
+
+// ImportRow is a parsed row of a user import, rows with Error set are only
+// reported back.
+type ImportRow struct {
+	Row  int
+	User User
+	// Omitted holds the first_name, last_name and timezone fields the row
+	// doesn't supply, an existing user keeps its values of them.
+	Omitted map[string]bool
+	// Defaults holds the default values of the attributes the row doesn't
+	// supply and CreateError why the row can't create a user, both only
+	// apply when there is no user with the email yet.
+	Defaults    Attributes
+	CreateError string
+	Error       string
+}
+
+type ImportResult struct {
+	Row    int        `json:"row" db:"row_num"`
+	Status string     `json:"status" db:"status"`
+	UserID *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Error  string     `json:"error,omitempty" db:"error"`
+}
+
+// UserExport is a user with the names of the groups the user is a member of.
+type UserExport struct {
+	User
+	Groups pq.StringArray `json:"groups" db:"groups"`
+}
+
+// GroupInfo is a group with the number of its members.
+type GroupInfo struct {
+	Group
+	Members int `json:"members" db:"members"`
+}
+
+// MembershipChange lists the users to add to or remove from a group, by id
+// or by email.
+type MembershipChange struct {
+	UserIDs []uuid.UUID `json:"user_ids"`
+	Emails  []string    `json:"emails"`
+}
+
+func (c MembershipChange) Validate() error {
+	return validation.ValidateStruct(&c,
+		validation.Field(&c.UserIDs, validation.By(func(interface{}) error {
+			n := len(c.UserIDs) + len(c.Emails)
+			if n == 0 {
+				return errors.New("user_ids or emails are required")
+			}
+			if n > MaxMembershipChange {
+				return fmt.Errorf("at most %d users can be changed at once", MaxMembershipChange)
+			}
+			return nil
+		})),
+	)
+}
+
+// MembershipResult is the outcome of a bulk membership change for one user,
+// the results follow the order of the user ids and then the emails.
+type MembershipResult struct {
+	UserID *uuid.UUID `json:"user_id,omitempty" db:"user_id"`
+	Email  string     `json:"email,omitempty" db:"email"`
+	Status string     `json:"status" db:"status"`
+}
+
+// Segment is a named set of users selected by an expression, the members are
+// resolved when the segment is used.
+type Segment struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	Name       string    `json:"name" db:"name"`
+	Expression string    `json:"expression" db:"expression"`
+	CreatedAt  string    `json:"created_at" db:"created_at"`
+}
+
+func (s Segment) Validate() error {
+	return validation.ValidateStruct(&s,
+		validation.Field(&s.Name, validation.Required, validation.Length(1, 255)),
+		validation.Field(&s.Expression, validation.Required, validation.By(validateExpression)),
+	)
+}
+
+func validateExpression(value interface{}) error {
+	_, err := segment.Parse(value.(string))
+	return err
+}
+
+// SegmentPreview is the number of users in a segment with a sample of them.
+type SegmentPreview struct {
+	Count int    `json:"count"`
+	Users []User `json:"users"`
+}
+
+// MemberPage is a page of group members, NextCursor is empty on the last
+// page.
+type MemberPage struct {
+	Users      []User `json:"users"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// MailExport is a mail without the body together with its delivery and
+// engagement outcome.
+type MailExport struct {
+	ID             uuid.UUID      `json:"id" db:"id"`
+	ToUserId       uuid.UUID      `json:"to_user_id" db:"to_user_id"`
+	Email          string         `json:"email" db:"email"`
+	GroupId        uuid.NullUUID  `json:"group_id" db:"group_id"`
+	Template       string         `json:"template" db:"template"`
+	Category       string         `json:"category" db:"category"`
+	Subject        string         `json:"subject" db:"subject"`
+	Status         string         `json:"status" db:"status"`
+	Error          sql.NullString `json:"error" db:"error"`
+	CreatedAt      string         `json:"created_at" db:"created_at"`
+	SentAt         sql.NullString `json:"sent_at" db:"sent_at"`
+	FirstOpenedAt  sql.NullString `json:"first_opened_at" db:"first_opened_at"`
+	OpenCount      int            `json:"open_count" db:"open_count"`
+	FirstClickedAt sql.NullString `json:"first_clicked_at" db:"first_clicked_at"`
+	ClickCount     int            `json:"click_count" db:"click_count"`
+	Complained     bool           `json:"complained" db:"complained"`
+}
+
+// UserFilter narrows down the user listing, zero fields are not applied.
+type UserFilter struct {
+	EmailPrefix   string
+	Name          string
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	GroupID       uuid.NullUUID
+	// Attributes are matched against the text form of the attribute values.
+	Attributes map[string]string
+	Limit      int
+	Offset     int
+}
+
+type Group struct {
+	ID          uuid.UUID `json:"id" db:"id"`
+	Name        string    `json:"name" db:"name"`
+	DoubleOptIn bool      `json:"double_opt_in" db:"double_opt_in"`
+	CreatedAt   string    `json:"created_at" db:"created_at"`
+}
+
 type Mail struct {
 	ID        uuid.UUID      `json:"id" db:"id"`
 	ToUserId  uuid.UUID      `json:"to_user_id" db:"to_user_id"`
+	GroupId   uuid.NullUUID  `json:"group_id" db:"group_id"`
+	SegmentId uuid.NullUUID  `json:"segment_id" db:"segment_id"`
+	Template  string         `json:"template" db:"template"`
+	Priority  string         `json:"priority" db:"priority"`
+	Category  string         `json:"category" db:"category"`
 	Subject   string         `json:"subject" db:"subject"`
 	Body      string         `json:"body" db:"body"`
+	Status    string         `json:"status" db:"status"`
+	Error     sql.NullString `json:"error" db:"error"`
 	CreatedAt string         `json:"created_at" db:"created_at"`
 	SentAt    sql.NullString `json:"sent_at" db:"sent_at"`
-	Watched   bool           `json:"watched" db:"watched"`
+
+	DeferredUntil sql.NullString `json:"deferred_until" db:"deferred_until"`
+
+	FirstOpenedAt sql.NullString `json:"first_opened_at" db:"first_opened_at"`
+	OpenCount     int            `json:"open_count" db:"open_count"`
+}
+
+type OpenEvent struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	MailID    uuid.UUID `json:"mail_id" db:"mail_id"`
+	OpenedAt  string    `json:"opened_at" db:"opened_at"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	Automated bool      `json:"automated" db:"automated"`
+}
+
+type Link struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	MailID    uuid.UUID `json:"mail_id" db:"mail_id"`
+	URL       string    `json:"url" db:"url"`
+	CreatedAt string    `json:"created_at" db:"created_at"`
+}
+
+type ClickEvent struct {
+	ID        uuid.UUID `json:"id" db:"id"`
+	LinkID    uuid.UUID `json:"link_id" db:"link_id"`
+	MailID    uuid.UUID `json:"mail_id" db:"mail_id"`
+	URL       string    `json:"url" db:"url"`
+	ClickedAt string    `json:"clicked_at" db:"clicked_at"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
 }
 
 type MailJson struct {
-	Subject string `json:"subject"`
-	Body    string `json:"body"`
-	SendAt  string `json:"send_at"`
+	Subject     string `json:"subject"`
+	Body        string `json:"body"`
+	SendAt      string `json:"send_at"`
+	SendAtLocal string `json:"send_at_local"`
+	Template    string `json:"template"`
+	Priority    string `json:"priority"`
+	Category    string `json:"category"`
+
+	// ExcludeGroups are skipped when sending to a group, with the groups
+	// they include.
+	ExcludeGroups []uuid.UUID `json:"exclude_groups"`
 }
 
 func (m *MailJson) Validate() error {
@@ -42,6 +526,15 @@ func (m *MailJson) Validate() error {
 		validation.Field(&m.Subject, validation.Required),
 		validation.Field(&m.Body, validation.Required),
 		validation.Field(&m.SendAt, validation.Date(time.RFC3339)),
+		validation.Field(&m.SendAtLocal, validation.Date(schedule.LocalLayout), validation.By(func(interface{}) error {
+			if m.SendAt != "" && m.SendAtLocal != "" {
+				return errors.New("can't be used together with send_at")
+			}
+			return nil
+		})),
+		validation.Field(&m.Template, validation.Match(templateNameRe)),
+		validation.Field(&m.Priority, validation.In(PriorityNormal, PriorityUrgent)),
+		validation.Field(&m.Category, validation.Match(templateNameRe)),
 	)
 }
 
@@ -55,3 +548,136 @@ type MailWithUser struct {
 	CreatedAt string         `json:"created_at" db:"created_at"`
 	SentAt    sql.NullString `json:"sent_at" db:"sent_at"`
 }
+
+type Stats struct {
+	Sent    int `json:"sent" db:"sent"`
+	Failed  int `json:"failed" db:"failed"`
+	Opened  int `json:"opened" db:"opened"`
+	Opens   int `json:"opens" db:"opens"`
+	Clicked int `json:"clicked" db:"clicked"`
+	Clicks  int `json:"clicks" db:"clicks"`
+}
+
+type TimeStats struct {
+	Bucket string `json:"bucket" db:"bucket"`
+	Stats
+}
+
+type GroupStats struct {
+	GroupID uuid.UUID `json:"group_id" db:"group_id"`
+	Name    string    `json:"name" db:"name"`
+	Stats
+}
+
+type TemplateStats struct {
+	Template string `json:"template" db:"template"`
+	Stats
+}
+
+type Webhook struct {
+	ID        uuid.UUID      `json:"id" db:"id"`
+	URL       string         `json:"url" db:"url"`
+	Events    pq.StringArray `json:"events" db:"events"`
+	Secret    string         `json:"secret,omitempty" db:"secret"`
+	CreatedAt string         `json:"created_at" db:"created_at"`
+}
+
+func (w *Webhook) Validate() error {
+	return validation.ValidateStruct(w,
+		validation.Field(&w.URL, validation.Required, is.URL),
+		validation.Field(&w.Events, validation.Required, validation.Each(validation.In(webhookEvents...))),
+		validation.Field(&w.Secret, validation.Required, validation.Length(16, 0)),
+	)
+}
+
+type WebhookDelivery struct {
+	ID         uuid.UUID      `json:"id" db:"id"`
+	WebhookID  uuid.UUID      `json:"webhook_id" db:"webhook_id"`
+	Event      string         `json:"event" db:"event"`
+	Payload    string         `json:"payload" db:"payload"`
+	Attempt    int            `json:"attempt" db:"attempt"`
+	StatusCode sql.NullInt32  `json:"status_code" db:"status_code"`
+	Error      sql.NullString `json:"error" db:"error"`
+	CreatedAt  string         `json:"created_at" db:"created_at"`
+}
+
+type Bounce struct {
+	ID             uuid.UUID `json:"id" db:"id"`
+	MailID         uuid.UUID `json:"mail_id" db:"mail_id"`
+	Recipient      string    `json:"recipient" db:"recipient"`
+	BounceType     string    `json:"bounce_type" db:"bounce_type"`
+	Status         string    `json:"status" db:"status"`
+	DiagnosticCode string    `json:"diagnostic_code" db:"diagnostic_code"`
+	CreatedAt      string    `json:"created_at" db:"created_at"`
+}
+
+type Suppression struct {
+	Address   string `json:"address" db:"address"`
+	Reason    string `json:"reason" db:"reason"`
+	Source    string `json:"source" db:"source"`
+	CreatedAt string `json:"created_at" db:"created_at"`
+}
+
+func (s *Suppression) Validate() error {
+	return validation.ValidateStruct(s,
+		validation.Field(&s.Address, validation.Required, is.Email),
+		validation.Field(&s.Reason, validation.Required, validation.In(
+			SuppressionBounce, SuppressionComplaint, SuppressionUnsubscribe, SuppressionManual, SuppressionErasure,
+		)),
+		validation.Field(&s.Source, validation.Required),
+	)
+}
+
+// HashAddress returns the form an address is kept in on the suppression list
+// after its owner has been erased.
+func HashAddress(address string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(address))))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+type GroupSubscription struct {
+	GroupID    uuid.UUID `json:"group_id" db:"group_id"`
+	Name       string    `json:"name" db:"name"`
+	Subscribed bool      `json:"subscribed" db:"subscribed"`
+}
+
+type PreferenceChange struct {
+	ID         uuid.UUID `json:"id" db:"id"`
+	UserID     uuid.UUID `json:"user_id" db:"user_id"`
+	GroupID    uuid.UUID `json:"group_id" db:"group_id"`
+	Subscribed bool      `json:"subscribed" db:"subscribed"`
+	Source     string    `json:"source" db:"source"`
+	CreatedAt  string    `json:"created_at" db:"created_at"`
+}
+
+type SubscriptionRequest struct {
+	ID          uuid.UUID      `json:"id" db:"id"`
+	UserID      uuid.UUID      `json:"user_id" db:"user_id"`
+	GroupID     uuid.UUID      `json:"group_id" db:"group_id"`
+	CreatedAt   string         `json:"created_at" db:"created_at"`
+	ExpiresAt   string         `json:"expires_at" db:"expires_at"`
+	ConfirmedAt sql.NullString `json:"confirmed_at" db:"confirmed_at"`
+}
+
+type Complaint struct {
+	ID           uuid.UUID `json:"id" db:"id"`
+	MailID       uuid.UUID `json:"mail_id" db:"mail_id"`
+	FeedbackType string    `json:"feedback_type" db:"feedback_type"`
+	UserAgent    string    `json:"user_agent" db:"user_agent"`
+	CreatedAt    string    `json:"created_at" db:"created_at"`
+}
+
+// UserArchive is everything stored about a user.
+type UserArchive struct {
+	User                 User                  `json:"user"`
+	Groups               []GroupSubscription   `json:"groups"`
+	PreferenceChanges    []PreferenceChange    `json:"preference_changes"`
+	SubscriptionRequests []SubscriptionRequest `json:"subscription_requests"`
+	Mails                []Mail                `json:"mails"`
+	OpenEvents           []OpenEvent           `json:"open_events"`
+	ClickEvents          []ClickEvent          `json:"click_events"`
+	Bounces              []Bounce              `json:"bounces"`
+	Complaints           []Complaint           `json:"complaints"`
+	Suppressions         []Suppression         `json:"suppressions"`
+	ExportedAt           time.Time             `json:"exported_at"`
+}
@@ -15,6 +15,7 @@ type Mail struct {
 
 type DelayedQueue interface {
 	Enqueue(ctx context.Context, mail Mail, runAt int64) error
+	Cancel(ctx context.Context, mails ...Mail) error
 	GetReadyChannel() <-chan []Mail
 	Run()
 	Stop()
@@ -60,6 +61,29 @@ func (q *queue) Enqueue(ctx context.Context, mail Mail, runAt int64) error {
 	return nil
 }
 
+// Cancel removes the mails from the queue, mails which are not queued are
+// ignored.
+func (q *queue) Cancel(ctx context.Context, mails ...Mail) error {
+	if len(mails) == 0 {
+		return nil
+	}
+
+	members := make([]interface{}, 0, len(mails))
+	for _, mail := range mails {
+		jsonMail, err := json.Marshal(mail)
+		if err != nil {
+			return fmt.Errorf("can't marshal mail: %w", err)
+		}
+		members = append(members, jsonMail)
+	}
+
+	_, err := q.rds.ZRem(ctx, "mails", members...).Result()
+	if err != nil {
+		return fmt.Errorf("can't cancel mails: %w", err)
+	}
+	return nil
+}
+
 func (q *queue) GetReadyChannel() <-chan []Mail {
 	return q.ready
 }
@@ -0,0 +1,78 @@
+package schedule
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const clockLayout = "15:04"
+
+// QuietHours is a daily window of wall clock time, e.g. 21:00-08:00, the
+// window may span midnight. The zero value has no quiet hours.
+type QuietHours struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseQuietHours parses a window in the form HH:MM-HH:MM.
+func ParseQuietHours(s string) (QuietHours, error) {
+	start, end, ok := strings.Cut(s, "-")
+	if !ok {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q", s)
+	}
+
+	var q QuietHours
+	var err error
+	if q.Start, err = parseClock(start); err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q: %w", s, err)
+	}
+	if q.End, err = parseClock(end); err != nil {
+		return QuietHours{}, fmt.Errorf("invalid quiet hours %q: %w", s, err)
+	}
+	return q, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(clockLayout, strings.TrimSpace(s))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Until reports whether t falls into the quiet hours in t's location and
+// returns the instant they end.
+func (q QuietHours) Until(t time.Time) (time.Time, bool) {
+	if q.Start == q.End {
+		return time.Time{}, false
+	}
+
+	h, m, s := t.Clock()
+	clock := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+
+	// The window ends on the same day unless it spans midnight and t is
+	// before midnight.
+	days := 0
+	if q.Start < q.End {
+		if clock < q.Start || clock >= q.End {
+			return time.Time{}, false
+		}
+	} else {
+		if clock < q.Start && clock >= q.End {
+			return time.Time{}, false
+		}
+		if clock >= q.Start {
+			days = 1
+		}
+	}
+
+	y, mo, d := t.Date()
+	end := time.Date(y, mo, d+days, 0, 0, 0, 0, time.UTC).Add(q.End)
+	return At(end, t.Location()), true
+}
+
+func (q QuietHours) String() string {
+	day := time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)
+	return day.Add(q.Start).Format(clockLayout) + "-" + day.Add(q.End).Format(clockLayout)
+}
@@ -0,0 +1,83 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    QuietHours
+		wantErr bool
+	}{
+		{s: "21:00-08:00", want: QuietHours{Start: 21 * time.Hour, End: 8 * time.Hour}},
+		{s: "12:30 - 14:15", want: QuietHours{Start: 12*time.Hour + 30*time.Minute, End: 14*time.Hour + 15*time.Minute}},
+		{s: "00:00-00:00", want: QuietHours{}},
+		{s: "21:00", wantErr: true},
+		{s: "25:00-08:00", wantErr: true},
+		{s: "21:00-8am", wantErr: true},
+		{s: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseQuietHours(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseQuietHours(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseQuietHours(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestQuietHoursString(t *testing.T) {
+	for _, s := range []string{"21:00-08:00", "12:30-14:15", "00:00-00:00"} {
+		q, err := ParseQuietHours(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if q.String() != s {
+			t.Errorf("String = %q, want %q", q.String(), s)
+		}
+	}
+}
+
+func TestQuietHoursUntil(t *testing.T) {
+	loc := newYork(t)
+	overnight := QuietHours{Start: 21 * time.Hour, End: 8 * time.Hour}
+	midday := QuietHours{Start: 12 * time.Hour, End: 14 * time.Hour}
+	intoGap := QuietHours{Start: 21 * time.Hour, End: 2*time.Hour + 30*time.Minute}
+
+	tests := []struct {
+		name      string
+		q         QuietHours
+		t         time.Time
+		want      time.Time
+		wantQuiet bool
+	}{
+		{name: "before midnight", q: overnight, t: time.Date(2024, 6, 1, 22, 0, 0, 0, loc), want: time.Date(2024, 6, 2, 8, 0, 0, 0, loc), wantQuiet: true},
+		{name: "after midnight", q: overnight, t: time.Date(2024, 6, 2, 7, 59, 0, 0, loc), want: time.Date(2024, 6, 2, 8, 0, 0, 0, loc), wantQuiet: true},
+		{name: "at the start", q: overnight, t: time.Date(2024, 6, 1, 21, 0, 0, 0, loc), want: time.Date(2024, 6, 2, 8, 0, 0, 0, loc), wantQuiet: true},
+		{name: "at the end", q: overnight, t: time.Date(2024, 6, 2, 8, 0, 0, 0, loc)},
+		{name: "daytime", q: overnight, t: time.Date(2024, 6, 1, 12, 0, 0, 0, loc)},
+		{name: "same day window", q: midday, t: time.Date(2024, 6, 1, 13, 0, 0, 0, loc), want: time.Date(2024, 6, 1, 14, 0, 0, 0, loc), wantQuiet: true},
+		{name: "outside same day window", q: midday, t: time.Date(2024, 6, 1, 11, 0, 0, 0, loc)},
+		{name: "ending over the fall back", q: overnight, t: time.Date(2024, 11, 2, 23, 0, 0, 0, loc), want: time.Date(2024, 11, 3, 8, 0, 0, 0, loc), wantQuiet: true},
+		{name: "ending in the gap", q: intoGap, t: time.Date(2024, 3, 9, 23, 0, 0, 0, loc), want: time.Date(2024, 3, 10, 7, 30, 0, 0, time.UTC), wantQuiet: true},
+		{name: "no quiet hours", q: QuietHours{}, t: time.Date(2024, 6, 1, 3, 0, 0, 0, loc)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, quiet := tt.q.Until(tt.t)
+			if quiet != tt.wantQuiet {
+				t.Fatalf("Until(%s) quiet = %v, want %v", tt.t, quiet, tt.wantQuiet)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("Until(%s) = %s, want %s", tt.t, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package schedule
+
+import "time"
+
+// LocalLayout is the format of wall clock times without a zone offset.
+const LocalLayout = "2006-01-02T15:04:05"
+
+// transitionWindow is how far around a time the zone offsets are looked up,
+// zones don't change their offset twice within it.
+const transitionWindow = 24 * time.Hour
+
+// At returns the instant the wall clock reading of wall, its location is
+// ignored, happens in loc. A reading skipped by a DST transition is moved
+// forward by the length of the gap, e.g. 02:30 becomes 03:30, and a reading
+// repeated by one resolves to its first occurrence.
+func At(wall time.Time, loc *time.Location) time.Time {
+	y, mo, d := wall.Date()
+	h, mi, s := wall.Clock()
+	utc := time.Date(y, mo, d, h, mi, s, wall.Nanosecond(), time.UTC)
+
+	_, before := utc.Add(-transitionWindow).In(loc).Zone()
+	_, after := utc.Add(transitionWindow).In(loc).Zone()
+
+	var result time.Time
+	for _, offset := range []int{before, after} {
+		candidate := utc.Add(-time.Duration(offset) * time.Second)
+		if _, actual := candidate.In(loc).Zone(); actual != offset {
+			continue
+		}
+		if result.IsZero() || candidate.Before(result) {
+			result = candidate
+		}
+	}
+
+	// The reading is in a gap, with the offset from before the transition it
+	// lands after the gap.
+	if result.IsZero() {
+		result = utc.Add(-time.Duration(before) * time.Second)
+	}
+
+	return result.In(loc)
+}
+
+// Location returns the named zone or UTC if the name is unknown.
+func Location(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
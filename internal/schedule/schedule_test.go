@@ -0,0 +1,68 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return loc
+}
+
+func TestAt(t *testing.T) {
+	loc := newYork(t)
+
+	tests := []struct {
+		name string
+		wall string
+		want string
+	}{
+		{name: "standard time", wall: "2024-01-15T09:00:00", want: "2024-01-15T14:00:00Z"},
+		{name: "daylight time", wall: "2024-06-01T09:00:00", want: "2024-06-01T13:00:00Z"},
+		{name: "before the gap", wall: "2024-03-10T01:59:00", want: "2024-03-10T06:59:00Z"},
+		{name: "in the gap", wall: "2024-03-10T02:30:00", want: "2024-03-10T07:30:00Z"},
+		{name: "after the gap", wall: "2024-03-10T03:00:00", want: "2024-03-10T07:00:00Z"},
+		{name: "repeated hour", wall: "2024-11-03T01:30:00", want: "2024-11-03T05:30:00Z"},
+		{name: "after the repeated hour", wall: "2024-11-03T02:00:00", want: "2024-11-03T07:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wall, err := time.Parse(LocalLayout, tt.wall)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := At(wall, loc)
+			if got.UTC().Format(time.RFC3339) != tt.want {
+				t.Errorf("At(%s) = %s, want %s", tt.wall, got.UTC().Format(time.RFC3339), tt.want)
+			}
+			if got.Location() != loc {
+				t.Errorf("At(%s) location = %s, want %s", tt.wall, got.Location(), loc)
+			}
+		})
+	}
+}
+
+func TestAtIgnoresWallLocation(t *testing.T) {
+	loc := newYork(t)
+	wall := time.Date(2024, 6, 1, 9, 0, 0, 0, time.FixedZone("UTC+9", 9*60*60))
+	want := time.Date(2024, 6, 1, 9, 0, 0, 0, loc)
+	if got := At(wall, loc); !got.Equal(want) {
+		t.Errorf("At = %s, want %s", got, want)
+	}
+}
+
+func TestLocation(t *testing.T) {
+	if got := Location("America/New_York"); got.String() != "America/New_York" {
+		t.Errorf("Location = %s, want America/New_York", got)
+	}
+	if got := Location("Nowhere/Unknown"); got != time.UTC {
+		t.Errorf("Location of an unknown zone = %s, want UTC", got)
+	}
+}
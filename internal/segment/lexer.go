@@ -0,0 +1,103 @@
+package segment
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenOp
+	tokenLParen
+	tokenRParen
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+// lex splits the expression into tokens, words are names, keywords, numbers
+// and unquoted values.
+func lex(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{kind: tokenLParen, text: "(", pos: i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{kind: tokenRParen, text: ")", pos: i})
+			i++
+		case c == '"' || c == '\'':
+			text, n, err := lexString(s[i:])
+			if err != nil {
+				return nil, &SyntaxError{Pos: i, Msg: err.Error()}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, pos: i})
+			i += n
+		case strings.ContainsRune("=!<>", c):
+			op := s[i : i+1]
+			if i+1 < len(s) && s[i+1] == '=' {
+				op = s[i : i+2]
+			}
+			if op == "!" {
+				return nil, &SyntaxError{Pos: i, Msg: `unexpected "!"`}
+			}
+			tokens = append(tokens, token{kind: tokenOp, text: op, pos: i})
+			i += len(op)
+		case isWordChar(c):
+			start := i
+			for i < len(s) && isWordChar(rune(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenWord, text: s[start:i], pos: start})
+		default:
+			return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("unexpected %q", c)}
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, pos: len(s)}), nil
+}
+
+// lexString reads a quoted string, the quote is escaped by doubling it.
+func lexString(s string) (string, int, error) {
+	quote := s[0]
+	var b strings.Builder
+
+	for i := 1; i < len(s); i++ {
+		if s[i] != quote {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == quote {
+			b.WriteByte(quote)
+			i++
+			continue
+		}
+		return b.String(), i + 1, nil
+	}
+
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+func isWordChar(c rune) bool {
+	return c < unicode.MaxASCII && (unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("_.@+-:", c))
+}
@@ -0,0 +1,371 @@
+// Package segment parses the rules which select the users of a segment, e.g.
+//
+//	opened(30d) AND plan = pro AND NOT in_group("Churned")
+//
+// A rule compares a user field or a custom attribute with a value, checks a
+// group membership or looks at the engagement within a period. Rules are
+// combined with AND, OR, NOT and parentheses.
+package segment
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User fields which can be compared, any other name is a custom attribute.
+// An attribute with the name of a field is referred to as attr.name.
+const (
+	FieldEmail     = "email"
+	FieldFirstName = "first_name"
+	FieldLastName  = "last_name"
+	FieldTimezone  = "timezone"
+	FieldCreatedAt = "created_at"
+)
+
+// Engagement kinds.
+const (
+	Opened   = "opened"
+	Clicked  = "clicked"
+	Received = "received"
+)
+
+const inGroup = "in_group"
+
+const attrPrefix = "attr."
+
+var fields = map[string]bool{
+	FieldEmail:     true,
+	FieldFirstName: true,
+	FieldLastName:  true,
+	FieldTimezone:  true,
+	FieldCreatedAt: true,
+}
+
+var ops = map[string]bool{"=": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+// Limits of an expression, longer or deeper nested ones are rejected before
+// they are parsed to the end.
+const (
+	MaxLength = 4096
+	MaxDepth  = 32
+)
+
+var dateLayouts = []string{time.RFC3339, "2006-01-02"}
+
+// SyntaxError reports the position of the error in the expression.
+type SyntaxError struct {
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("segment: %s at %d", e.Msg, e.Pos)
+}
+
+// Expr is a node of a parsed expression.
+type Expr interface {
+	expr()
+}
+
+type And struct {
+	Left, Right Expr
+}
+
+type Or struct {
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+// Compare compares a field or an attribute with a value, the value is a
+// string, a float64, a bool or, for created_at, a time.Time.
+type Compare struct {
+	Name      string
+	Attribute bool
+	Op        string
+	Value     interface{}
+}
+
+// InGroup matches the members of the named group.
+type InGroup struct {
+	Name string
+}
+
+// Engaged matches users who opened, clicked or received a mail within the
+// period.
+type Engaged struct {
+	Kind   string
+	Within time.Duration
+}
+
+func (And) expr()     {}
+func (Or) expr()      {}
+func (Not) expr()     {}
+func (Compare) expr() {}
+func (InGroup) expr() {}
+func (Engaged) expr() {}
+
+// Parse parses the expression.
+func Parse(s string) (Expr, error) {
+	if len(s) > MaxLength {
+		return nil, &SyntaxError{Pos: MaxLength, Msg: fmt.Sprintf("expression is longer than %d bytes", MaxLength)}
+	}
+
+	tokens, err := lex(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, p.unexpected(t)
+	}
+	return e, nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) keyword(word string) bool {
+	t := p.peek()
+	if t.kind == tokenWord && strings.EqualFold(t.text, word) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind) (token, error) {
+	t := p.next()
+	if t.kind != kind {
+		return token{}, p.unexpected(t)
+	}
+	return t, nil
+}
+
+// enter counts a level of nesting at t, the caller leaves it with a deferred
+// p.leave().
+func (p *parser) enter(t token) error {
+	p.depth++
+	if p.depth > MaxDepth {
+		return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("expression is nested deeper than %d levels", MaxDepth)}
+	}
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) unexpected(t token) error {
+	return &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+}
+
+func (p *parser) or() (Expr, error) {
+	left, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("OR") {
+		right, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) and() (Expr, error) {
+	left, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("AND") {
+		right, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		left = And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) not() (Expr, error) {
+	t := p.peek()
+	if p.keyword("NOT") {
+		if err := p.enter(t); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Expr: e}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (Expr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenLParen:
+		if err := p.enter(t); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if _, err = p.expect(tokenRParen); err != nil {
+			return nil, err
+		}
+		return e, nil
+	case tokenWord:
+		if p.peek().kind == tokenLParen {
+			return p.call(t)
+		}
+		return p.compare(t)
+	default:
+		return nil, p.unexpected(t)
+	}
+}
+
+// call parses in_group("name"), opened(30d), clicked(30d) and received(30d).
+func (p *parser) call(name token) (Expr, error) {
+	p.next()
+	arg := p.next()
+	if arg.kind != tokenWord && arg.kind != tokenString {
+		return nil, p.unexpected(arg)
+	}
+	if _, err := p.expect(tokenRParen); err != nil {
+		return nil, err
+	}
+
+	switch kind := strings.ToLower(name.text); kind {
+	case inGroup:
+		return InGroup{Name: arg.text}, nil
+	case Opened, Clicked, Received:
+		within, err := parsePeriod(arg.text)
+		if err != nil {
+			return nil, &SyntaxError{Pos: arg.pos, Msg: fmt.Sprintf("invalid period %q", arg.text)}
+		}
+		return Engaged{Kind: kind, Within: within}, nil
+	default:
+		return nil, &SyntaxError{Pos: name.pos, Msg: fmt.Sprintf("unknown function %q", name.text)}
+	}
+}
+
+func (p *parser) compare(name token) (Expr, error) {
+	op, err := p.expect(tokenOp)
+	if err != nil {
+		return nil, err
+	}
+	if !ops[op.text] {
+		return nil, p.unexpected(op)
+	}
+	value := p.next()
+	if value.kind != tokenWord && value.kind != tokenString {
+		return nil, p.unexpected(value)
+	}
+
+	c := Compare{Name: name.text, Op: op.text}
+	if attr := strings.TrimPrefix(name.text, attrPrefix); attr != name.text {
+		c.Name, c.Attribute = attr, true
+	} else if !fields[name.text] {
+		c.Attribute = true
+	}
+
+	switch {
+	case c.Attribute:
+		c.Value = literal(value)
+		if _, ok := c.Value.(bool); ok && op.text != "=" && op.text != "!=" {
+			return nil, &SyntaxError{Pos: op.pos, Msg: fmt.Sprintf("%s can't be used with a boolean", op.text)}
+		}
+	case c.Name == FieldCreatedAt:
+		if c.Value, err = parseDate(value.text); err != nil {
+			return nil, &SyntaxError{Pos: value.pos, Msg: fmt.Sprintf("invalid date %q", value.text)}
+		}
+	default:
+		c.Value = value.text
+	}
+
+	return c, nil
+}
+
+// literal returns the value of an unquoted word as a bool or a number if it
+// is one, quoted values are always strings.
+func literal(t token) interface{} {
+	if t.kind == tokenString {
+		return t.text
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true
+	case "false":
+		return false
+	}
+	if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return n
+	}
+	return t.text
+}
+
+// parsePeriod parses a duration which may be in days, e.g. 30d.
+func parsePeriod(s string) (time.Duration, error) {
+	var d time.Duration
+	if days := strings.TrimSuffix(s, "d"); days != s {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("period must be positive")
+	}
+	return d, nil
+}
+
+func parseDate(s string) (time.Time, error) {
+	var err error
+	for _, layout := range dateLayouts {
+		var t time.Time
+		if t, err = time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
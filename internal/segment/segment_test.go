@@ -0,0 +1,141 @@
+package segment
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want Expr
+	}{
+		{
+			name: "attribute with bare string",
+			in:   "plan = pro",
+			want: Compare{Name: "plan", Attribute: true, Op: "=", Value: "pro"},
+		},
+		{
+			name: "attribute with number and bool",
+			in:   "score >= 10 OR vip = true",
+			want: Or{
+				Left:  Compare{Name: "score", Attribute: true, Op: ">=", Value: 10.0},
+				Right: Compare{Name: "vip", Attribute: true, Op: "=", Value: true},
+			},
+		},
+		{
+			name: "quoted number is a string",
+			in:   `zip = "01234"`,
+			want: Compare{Name: "zip", Attribute: true, Op: "=", Value: "01234"},
+		},
+		{
+			name: "escaped quote",
+			in:   `a = 'it''s'`,
+			want: Compare{Name: "a", Attribute: true, Op: "=", Value: "it's"},
+		},
+		{
+			name: "field",
+			in:   "email != a@b.example",
+			want: Compare{Name: FieldEmail, Op: "!=", Value: "a@b.example"},
+		},
+		{
+			name: "attribute named like a field",
+			in:   `attr.email = "x"`,
+			want: Compare{Name: "email", Attribute: true, Op: "=", Value: "x"},
+		},
+		{
+			name: "created_at date",
+			in:   "created_at > 2024-01-02",
+			want: Compare{Name: FieldCreatedAt, Op: ">", Value: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "functions",
+			in:   `opened(30d) AND NOT in_group("Churned") OR clicked(12h)`,
+			want: Or{
+				Left: And{
+					Left:  Engaged{Kind: Opened, Within: 30 * 24 * time.Hour},
+					Right: Not{Expr: InGroup{Name: "Churned"}},
+				},
+				Right: Engaged{Kind: Clicked, Within: 12 * time.Hour},
+			},
+		},
+		{
+			name: "parentheses and lowercase keywords",
+			in:   "a = 1 and (b = 2 or c = 3)",
+			want: And{
+				Left: Compare{Name: "a", Attribute: true, Op: "=", Value: 1.0},
+				Right: Or{
+					Left:  Compare{Name: "b", Attribute: true, Op: "=", Value: 2.0},
+					Right: Compare{Name: "c", Attribute: true, Op: "=", Value: 3.0},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		pos  int
+	}{
+		{name: "empty", in: "", pos: 0},
+		{name: "double equals", in: "plan == x", pos: 5},
+		{name: "missing value", in: "x = ", pos: 4},
+		{name: "unclosed call", in: "opened(", pos: 7},
+		{name: "unclosed paren", in: "(a = 1", pos: 6},
+		{name: "trailing token", in: "a = 1 b", pos: 6},
+		{name: "unterminated string", in: `a = "x`, pos: 4},
+		{name: "unknown function", in: "foo(1)", pos: 0},
+		{name: "zero period", in: "opened(0d)", pos: 7},
+		{name: "bad date", in: "created_at > yesterday", pos: 13},
+		{name: "ordered boolean", in: "vip > true", pos: 4},
+		{name: "unexpected character", in: "a = 1 & b = 2", pos: 6},
+		{name: "too long", in: "a = " + strings.Repeat("x", MaxLength), pos: MaxLength},
+		{name: "too deep parens", in: strings.Repeat("(", MaxDepth+1) + "a = 1" + strings.Repeat(")", MaxDepth+1), pos: MaxDepth},
+		{name: "too deep not", in: strings.Repeat("NOT ", MaxDepth+1) + "a = 1", pos: 4 * MaxDepth},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(tt.in)
+			var syntax *SyntaxError
+			if !errors.As(err, &syntax) {
+				t.Fatalf("Parse(%q) error = %v, want a SyntaxError", tt.in, err)
+			}
+			if syntax.Pos != tt.pos {
+				t.Errorf("Parse(%q) error at %d, want %d: %v", tt.in, syntax.Pos, tt.pos, err)
+			}
+		})
+	}
+}
+
+func TestParseMaxDepth(t *testing.T) {
+	in := strings.Repeat("(", MaxDepth) + "a = 1" + strings.Repeat(")", MaxDepth)
+	if _, err := Parse(in); err != nil {
+		t.Errorf("Parse at the max depth error: %v", err)
+	}
+}
+
+// A huge input has to fail fast instead of overflowing the stack.
+func TestParseHugeInput(t *testing.T) {
+	if _, err := Parse(strings.Repeat("(", 4<<20)); err == nil {
+		t.Error("Parse of a huge input succeeded")
+	}
+}
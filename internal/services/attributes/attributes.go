@@ -0,0 +1,101 @@
+package attributes
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mime"
+	"net/http"
+)
+
+type AttributeHandlers interface {
+	Register(r chi.Router)
+	PostAttributeDefinition(w http.ResponseWriter, r *http.Request)
+	GetAttributeDefinitions(w http.ResponseWriter, r *http.Request)
+	DeleteAttributeDefinition(w http.ResponseWriter, r *http.Request)
+}
+
+type attributeHandlers struct {
+	storage storage.Attribute
+}
+
+func NewAttributeHandlers(storage storage.Attribute) AttributeHandlers {
+	return &attributeHandlers{storage: storage}
+}
+
+func (s *attributeHandlers) Register(r chi.Router) {
+	r.Post("/", s.PostAttributeDefinition)
+	r.Get("/", s.GetAttributeDefinitions)
+	r.Delete("/{name}", s.DeleteAttributeDefinition)
+}
+
+// PostAttributeDefinition declares an attribute or replaces its declaration,
+// existing users are checked against it on their next update.
+func (s *attributeHandlers) PostAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var definition model.AttributeDefinition
+	err = json.NewDecoder(r.Body).Decode(&definition)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = definition.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.CreateAttributeDefinition(r.Context(), definition)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *attributeHandlers) GetAttributeDefinitions(w http.ResponseWriter, r *http.Request) {
+	definitions, err := s.storage.GetAttributeDefinitions(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(definitions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *attributeHandlers) DeleteAttributeDefinition(w http.ResponseWriter, r *http.Request) {
+	err := s.storage.DeleteAttributeDefinition(r.Context(), chi.URLParam(r, "name"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,87 @@
+package attributes
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeAttributes struct {
+	storage.Attribute
+	definitions map[string]model.AttributeDefinition
+}
+
+func (f *fakeAttributes) CreateAttributeDefinition(_ context.Context, definition model.AttributeDefinition) error {
+	f.definitions[definition.Name] = definition
+	return nil
+}
+
+func (f *fakeAttributes) DeleteAttributeDefinition(_ context.Context, name string) error {
+	if _, ok := f.definitions[name]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(f.definitions, name)
+	return nil
+}
+
+func TestPostAttributeDefinition(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "string", contentType: "application/json", body: `{"name":"plan","type":"string"}`, wantStatus: http.StatusCreated},
+		{name: "with default", contentType: "application/json", body: `{"name":"score","type":"number","default":10}`, wantStatus: http.StatusCreated},
+		{name: "unknown type", contentType: "application/json", body: `{"name":"plan","type":"date"}`, wantStatus: http.StatusBadRequest},
+		{name: "default of another type", contentType: "application/json", body: `{"name":"score","type":"number","default":"ten"}`, wantStatus: http.StatusBadRequest},
+		{name: "no name", contentType: "application/json", body: `{"type":"string"}`, wantStatus: http.StatusBadRequest},
+		{name: "text", contentType: "text/plain", body: "plan", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attributes := &fakeAttributes{definitions: map[string]model.AttributeDefinition{}}
+			r := chi.NewRouter()
+			NewAttributeHandlers(attributes).Register(r)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if created := len(attributes.definitions) == 1; created != (tt.wantStatus == http.StatusCreated) {
+				t.Errorf("definitions = %+v", attributes.definitions)
+			}
+		})
+	}
+}
+
+func TestDeleteAttributeDefinition(t *testing.T) {
+	attributes := &fakeAttributes{definitions: map[string]model.AttributeDefinition{"plan": {Name: "plan", Type: "string"}}}
+	r := chi.NewRouter()
+	NewAttributeHandlers(attributes).Register(r)
+
+	for _, tt := range []struct {
+		name       string
+		wantStatus int
+	}{
+		{name: "plan", wantStatus: http.StatusOK},
+		{name: "plan", wantStatus: http.StatusNotFound},
+	} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/"+tt.name, nil))
+		if w.Code != tt.wantStatus {
+			t.Errorf("DELETE %s status = %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Proxies are the networks of the reverse proxies in front of the service,
+// X-Forwarded-For is only honored on requests coming from them.
+type Proxies []*net.IPNet
+
+// ParseProxies parses addresses and CIDR networks, e.g. 10.0.0.0/8.
+func ParseProxies(values []string) (Proxies, error) {
+	proxies := make(Proxies, 0, len(values))
+	for _, value := range values {
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy address %q", value)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy network %q: %w", value, err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+func (p Proxies) trusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range p {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IP returns the address of the client which made the request. When the
+// request comes from a trusted proxy, X-Forwarded-For is read from the right
+// and the first address which isn't a trusted proxy is the client, so
+// addresses the client put into the header itself are skipped.
+func (p Proxies) IP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !p.trusted(ip) {
+		return ip
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !p.trusted(hop) {
+			break
+		}
+	}
+	return ip
+}
@@ -0,0 +1,101 @@
+package client
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProxiesIP(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"})
+	if err != nil {
+		t.Fatalf("ParseProxies error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		proxies   Proxies
+		remote    string
+		forwarded []string
+		want      string
+	}{
+		{name: "direct", proxies: proxies, remote: "203.0.113.7:1234", want: "203.0.113.7"},
+		{
+			name:      "spoofed header from an untrusted peer",
+			proxies:   proxies,
+			remote:    "203.0.113.7:1234",
+			forwarded: []string{"198.51.100.1"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "trusted proxy",
+			proxies:   proxies,
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "client prepended an address",
+			proxies:   proxies,
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"198.51.100.1, 203.0.113.7"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "chain of trusted proxies",
+			proxies:   proxies,
+			remote:    "192.0.2.1:1234",
+			forwarded: []string{"203.0.113.7, 10.0.0.5", "10.0.0.6"},
+			want:      "203.0.113.7",
+		},
+		{
+			name:      "only proxies in the header",
+			proxies:   proxies,
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"10.0.0.5"},
+			want:      "10.0.0.5",
+		},
+		{
+			name:      "trusted proxy without the header",
+			proxies:   proxies,
+			remote:    "10.1.2.3:1234",
+			forwarded: nil,
+			want:      "10.1.2.3",
+		},
+		{
+			name:      "ipv6 proxy",
+			proxies:   proxies,
+			remote:    "[2001:db8::1]:1234",
+			forwarded: []string{"2001:db8::2"},
+			want:      "2001:db8::2",
+		},
+		{
+			name:      "no trusted proxies",
+			proxies:   nil,
+			remote:    "10.1.2.3:1234",
+			forwarded: []string{"203.0.113.7"},
+			want:      "10.1.2.3",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := tt.proxies.IP(r); got != tt.want {
+				t.Errorf("IP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxiesErrors(t *testing.T) {
+	for _, value := range []string{"", "10.0.0.0/33", "proxy.example", "10.0.0"} {
+		if _, err := ParseProxies([]string{value}); err == nil {
+			t.Errorf("ParseProxies(%q) succeeded", value)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package complaint
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"mail-service/internal/inbound"
+	"net/http"
+)
+
+const maxReportBytes = 10 << 20
+
+type ComplaintHandlers interface {
+	Register(r chi.Router)
+	PostComplaint(w http.ResponseWriter, r *http.Request)
+}
+
+type complaintHandlers struct {
+	complaints *inbound.Complaints
+}
+
+func NewComplaintHandlers(complaints *inbound.Complaints) ComplaintHandlers {
+	return &complaintHandlers{complaints: complaints}
+}
+
+func (s *complaintHandlers) Register(r chi.Router) {
+	r.Post("/", s.PostComplaint)
+}
+
+// PostComplaint accepts a raw ARF report for providers which deliver
+// feedback over HTTP or for reports forwarded by hand.
+func (s *complaintHandlers) PostComplaint(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxReportBytes)
+
+	err := s.complaints.Process(r.Context(), body)
+	if errors.Is(err, inbound.ErrNotReport) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	} else if errors.Is(err, inbound.ErrUnknownMail) || errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
@@ -0,0 +1,152 @@
+package confirm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"html/template"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/services/mail"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"net/http"
+	"time"
+)
+
+// Confirmer asks users to confirm their subscription to double opt-in groups.
+type Confirmer interface {
+	RequestConfirmation(ctx context.Context, userId uuid.UUID, group model.Group) error
+}
+
+type ConfirmHandlers interface {
+	Confirmer
+	Register(r chi.Router)
+	GetConfirmPage(w http.ResponseWriter, r *http.Request)
+	PostConfirm(w http.ResponseWriter, r *http.Request)
+}
+
+type confirmHandlers struct {
+	subscriptions storage.Subscription
+	sender        mail.Sender
+	signer        *token.Signer
+
+	host string
+	ttl  time.Duration
+}
+
+// NewConfirmHandlers creates handlers for subscription confirmations, the
+// requests have to be confirmed within ttl.
+func NewConfirmHandlers(host string, ttl time.Duration, subscriptions storage.Subscription, sender mail.Sender, signer *token.Signer) ConfirmHandlers {
+	return &confirmHandlers{subscriptions: subscriptions, sender: sender, signer: signer, host: host, ttl: ttl}
+}
+
+func (s *confirmHandlers) Register(r chi.Router) {
+	r.Get("/{token}", s.GetConfirmPage)
+	r.Post("/{token}", s.PostConfirm)
+}
+
+// RequestConfirmation creates a pending subscription request and sends the
+// user a mail with the link to confirm it.
+func (s *confirmHandlers) RequestConfirmation(ctx context.Context, userId uuid.UUID, group model.Group) error {
+	err := s.subscriptions.DeleteExpiredSubscriptionRequests(ctx)
+	if err != nil {
+		log.Println(err)
+	}
+
+	id, err := s.subscriptions.CreateSubscriptionRequest(ctx, userId, group.ID, time.Now().Add(s.ttl))
+	if err != nil {
+		return fmt.Errorf("can't create subscription request: %w", err)
+	}
+
+	// The user is waiting for the mail, so it isn't held for quiet hours.
+	err = s.sender.CreateAndSend(ctx, model.Mail{
+		ToUserId: userId,
+		Template: model.ConfirmTemplate,
+		Priority: model.PriorityUrgent,
+		Subject:  fmt.Sprintf("Confirm your subscription to %s", group.Name),
+		Body:     fmt.Sprintf("%s/confirm/%s", s.host, s.signer.Sign(token.Confirm, id)),
+	})
+	if err != nil {
+		return fmt.Errorf("can't send confirmation: %w", err)
+	}
+
+	return nil
+}
+
+// GetConfirmPage only shows a button, so link scanners following the link
+// don't confirm the subscription.
+func (s *confirmHandlers) GetConfirmPage(w http.ResponseWriter, r *http.Request) {
+	id, err := s.signer.Verify(token.Confirm, chi.URLParam(r, "token"))
+	if errors.Is(err, token.ErrExpired) {
+		renderPage(w, pageData{Expired: true})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	request, err := s.subscriptions.GetSubscriptionRequest(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		renderPage(w, pageData{Expired: true})
+		return
+	}
+
+	renderPage(w, pageData{Done: request.ConfirmedAt.Valid})
+}
+
+func (s *confirmHandlers) PostConfirm(w http.ResponseWriter, r *http.Request) {
+	id, err := s.signer.Verify(token.Confirm, chi.URLParam(r, "token"))
+	if errors.Is(err, token.ErrExpired) {
+		renderPage(w, pageData{Expired: true})
+		return
+	} else if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	request, err := s.subscriptions.GetSubscriptionRequest(r.Context(), id)
+	if err == nil && request.ConfirmedAt.Valid {
+		renderPage(w, pageData{Done: true})
+		return
+	}
+
+	err = s.subscriptions.ConfirmSubscriptionRequest(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		renderPage(w, pageData{Expired: true})
+		return
+	}
+
+	renderPage(w, pageData{Done: true})
+}
+
+type pageData struct {
+	Done    bool
+	Expired bool
+}
+
+func renderPage(w http.ResponseWriter, data pageData) {
+	tmpl, err := template.ParseFiles("templates/pages/confirm.html")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
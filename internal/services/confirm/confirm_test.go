@@ -0,0 +1,162 @@
+package confirm
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/services/mail"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain runs the tests from the repository root, where the pages are
+// loaded from.
+func TestMain(m *testing.M) {
+	if err := os.Chdir("../../.."); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+type fakeSubscriptions struct {
+	storage.Subscription
+	requests  map[uuid.UUID]*model.SubscriptionRequest
+	confirmed []uuid.UUID
+}
+
+func (f *fakeSubscriptions) GetSubscriptionRequest(_ context.Context, id uuid.UUID) (model.SubscriptionRequest, error) {
+	request, ok := f.requests[id]
+	if !ok {
+		return model.SubscriptionRequest{}, sql.ErrNoRows
+	}
+	return *request, nil
+}
+
+func (f *fakeSubscriptions) ConfirmSubscriptionRequest(_ context.Context, id uuid.UUID) error {
+	request, ok := f.requests[id]
+	if !ok || request.ConfirmedAt.Valid {
+		return sql.ErrNoRows
+	}
+	request.ConfirmedAt = sql.NullString{String: "2024-01-01T00:00:00Z", Valid: true}
+	f.confirmed = append(f.confirmed, id)
+	return nil
+}
+
+func (f *fakeSubscriptions) DeleteExpiredSubscriptionRequests(_ context.Context) error {
+	return nil
+}
+
+func (f *fakeSubscriptions) CreateSubscriptionRequest(_ context.Context, userID, groupID uuid.UUID, expiresAt time.Time) (uuid.UUID, error) {
+	id := uuid.New()
+	f.requests[id] = &model.SubscriptionRequest{ID: id, UserID: userID, GroupID: groupID}
+	return id, nil
+}
+
+type fakeSender struct {
+	mail.Sender
+	mails []model.Mail
+}
+
+func (f *fakeSender) CreateAndSend(_ context.Context, mail model.Mail) error {
+	f.mails = append(f.mails, mail)
+	return nil
+}
+
+func TestConfirm(t *testing.T) {
+	keys := []token.Key{{ID: "k1", Secret: []byte("secret")}}
+	signer, err := token.NewSigner(keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := token.NewSigner(keys, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pending, confirmed := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name          string
+		method        string
+		token         string
+		wantStatus    int
+		wantPage      string
+		wantConfirmed bool
+	}{
+		{name: "page", method: http.MethodGet, token: signer.Sign(token.Confirm, pending), wantStatus: http.StatusOK, wantPage: "Confirm subscription"},
+		{name: "confirm", method: http.MethodPost, token: signer.Sign(token.Confirm, pending), wantStatus: http.StatusOK, wantPage: "Your subscription is confirmed", wantConfirmed: true},
+		{name: "page of a confirmed request", method: http.MethodGet, token: signer.Sign(token.Confirm, confirmed), wantStatus: http.StatusOK, wantPage: "Your subscription is confirmed"},
+		{name: "replayed", method: http.MethodPost, token: signer.Sign(token.Confirm, confirmed), wantStatus: http.StatusOK, wantPage: "Your subscription is confirmed"},
+		{name: "expired page", method: http.MethodGet, token: expired.Sign(token.Confirm, pending), wantStatus: http.StatusOK, wantPage: "This link has expired"},
+		{name: "expired", method: http.MethodPost, token: expired.Sign(token.Confirm, pending), wantStatus: http.StatusOK, wantPage: "This link has expired"},
+		{name: "deleted request", method: http.MethodPost, token: signer.Sign(token.Confirm, uuid.New()), wantStatus: http.StatusOK, wantPage: "This link has expired"},
+		{name: "other purpose", method: http.MethodPost, token: signer.Sign(token.Unsubscribe, pending), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subscriptions := &fakeSubscriptions{requests: map[uuid.UUID]*model.SubscriptionRequest{
+				pending:   {ID: pending},
+				confirmed: {ID: confirmed, ConfirmedAt: sql.NullString{String: "2024-01-01T00:00:00Z", Valid: true}},
+			}}
+			r := chi.NewRouter()
+			NewConfirmHandlers("https://mail.example.com", time.Hour, subscriptions, nil, signer).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(tt.method, "/"+tt.token, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantPage) {
+				t.Errorf("body = %s, want %q", w.Body.String(), tt.wantPage)
+			}
+			if got := len(subscriptions.confirmed) == 1; got != tt.wantConfirmed {
+				t.Errorf("confirmed = %v, want confirmed %v", subscriptions.confirmed, tt.wantConfirmed)
+			}
+		})
+	}
+}
+
+func TestRequestConfirmation(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subscriptions := &fakeSubscriptions{requests: map[uuid.UUID]*model.SubscriptionRequest{}}
+	sender := &fakeSender{}
+	userID := uuid.New()
+	group := model.Group{ID: uuid.New(), Name: "news"}
+
+	err = NewConfirmHandlers("https://mail.example.com", time.Hour, subscriptions, sender, signer).RequestConfirmation(context.Background(), userID, group)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sender.mails) != 1 || len(subscriptions.requests) != 1 {
+		t.Fatalf("mails = %v, requests = %v, want one", sender.mails, subscriptions.requests)
+	}
+	m := sender.mails[0]
+	if m.ToUserId != userID || m.Template != model.ConfirmTemplate {
+		t.Errorf("mail = %+v, want a %s mail to %s", m, model.ConfirmTemplate, userID)
+	}
+	if m.Priority != model.PriorityUrgent {
+		t.Errorf("priority = %q, want %q", m.Priority, model.PriorityUrgent)
+	}
+
+	id, err := signer.Verify(token.Confirm, strings.TrimPrefix(m.Body, "https://mail.example.com/confirm/"))
+	if err != nil {
+		t.Fatalf("confirmation link %q doesn't verify: %v", m.Body, err)
+	}
+	if request, ok := subscriptions.requests[id]; !ok || request.UserID != userID || request.GroupID != group.ID {
+		t.Errorf("link leads to request %+v, want one of %s for %s", request, userID, group.ID)
+	}
+}
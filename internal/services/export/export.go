@@ -0,0 +1,228 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	formatCsv    = "csv"
+	formatNdjson = "ndjson"
+)
+
+var (
+	userHeader  = []string{"id", "email", "first_name", "last_name", "timezone", "attributes", "groups", "created_at"}
+	groupHeader = []string{"id", "name", "double_opt_in", "members", "created_at"}
+	mailHeader  = []string{
+		"id", "to_user_id", "email", "group_id", "template", "category", "subject", "status", "error", "created_at", "sent_at",
+		"first_opened_at", "open_count", "first_clicked_at", "click_count", "complained",
+	}
+)
+
+type ExportHandlers interface {
+	Register(r chi.Router)
+	GetUsers(w http.ResponseWriter, r *http.Request)
+	GetGroups(w http.ResponseWriter, r *http.Request)
+	GetMails(w http.ResponseWriter, r *http.Request)
+}
+
+type exportHandlers struct {
+	storage storage.Export
+}
+
+func NewExportHandlers(storage storage.Export) ExportHandlers {
+	return &exportHandlers{storage: storage}
+}
+
+func (s *exportHandlers) Register(r chi.Router) {
+	r.Get("/users", s.GetUsers)
+	r.Get("/groups", s.GetGroups)
+	r.Get("/mails", s.GetMails)
+}
+
+func (s *exportHandlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	out, ok := newRecordWriter(w, r, "users", userHeader)
+	if !ok {
+		return
+	}
+
+	err := s.storage.ExportUsers(r.Context(), func(user model.UserExport) error {
+		return out.write(user, func() ([]string, error) {
+			attributes, err := json.Marshal(user.Attributes)
+			if err != nil {
+				return nil, err
+			}
+			groups, err := json.Marshal(user.Groups)
+			if err != nil {
+				return nil, err
+			}
+			return []string{
+				user.ID.String(), user.Email, user.FirstName, user.LastName, user.Timezone, string(attributes), string(groups),
+				user.CreatedAt,
+			}, nil
+		})
+	})
+	out.finish(err)
+}
+
+func (s *exportHandlers) GetGroups(w http.ResponseWriter, r *http.Request) {
+	out, ok := newRecordWriter(w, r, "groups", groupHeader)
+	if !ok {
+		return
+	}
+
+	err := s.storage.ExportGroups(r.Context(), func(group model.GroupInfo) error {
+		return out.write(group, func() ([]string, error) {
+			return []string{
+				group.ID.String(), group.Name, strconv.FormatBool(group.DoubleOptIn), strconv.Itoa(group.Members), group.CreatedAt,
+			}, nil
+		})
+	})
+	out.finish(err)
+}
+
+// GetMails exports the mails created in the period given by the optional
+// from and to query params, all mails by default.
+func (s *exportHandlers) GetMails(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parsePeriod(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	out, ok := newRecordWriter(w, r, "mails", mailHeader)
+	if !ok {
+		return
+	}
+
+	err := s.storage.ExportMails(r.Context(), from, to, func(mail model.MailExport) error {
+		return out.write(mail, func() ([]string, error) {
+			return []string{
+				mail.ID.String(), mail.ToUserId.String(), mail.Email, nullUUID(mail.GroupId), mail.Template, mail.Category, mail.Subject,
+				mail.Status, mail.Error.String, mail.CreatedAt, mail.SentAt.String, mail.FirstOpenedAt.String,
+				strconv.Itoa(mail.OpenCount), mail.FirstClickedAt.String, strconv.Itoa(mail.ClickCount),
+				strconv.FormatBool(mail.Complained),
+			}, nil
+		})
+	})
+	out.finish(err)
+}
+
+// recordWriter writes the exported records as CSV or NDJSON, the format is
+// chosen by the format query param.
+type recordWriter struct {
+	w       http.ResponseWriter
+	csv     *csv.Writer
+	json    *json.Encoder
+	header  []string
+	started bool
+}
+
+func newRecordWriter(w http.ResponseWriter, r *http.Request, name string, header []string) (*recordWriter, bool) {
+	out := &recordWriter{w: w, header: header}
+
+	format := r.URL.Query().Get("format")
+	switch format {
+	case formatNdjson, "":
+		format = formatNdjson
+		out.json = json.NewEncoder(w)
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	case formatCsv:
+		out.csv = csv.NewWriter(w)
+		w.Header().Set("Content-Type", "text/csv")
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		return nil, false
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.%s"`, name, format))
+	return out, true
+}
+
+// write encodes the record, fields is only called for CSV.
+func (o *recordWriter) write(record interface{}, fields func() ([]string, error)) error {
+	o.started = true
+
+	if o.json != nil {
+		return o.json.Encode(record)
+	}
+
+	if o.header != nil {
+		if err := o.csv.Write(o.header); err != nil {
+			return err
+		}
+		o.header = nil
+	}
+
+	row, err := fields()
+	if err != nil {
+		return err
+	}
+	return o.csv.Write(row)
+}
+
+// finish flushes the output, the status can only be changed if nothing has
+// been written yet.
+func (o *recordWriter) finish(err error) {
+	if err != nil {
+		log.Println(err)
+		if !o.started {
+			o.w.Header().Del("Content-Disposition")
+			o.w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if o.csv != nil {
+		if o.header != nil {
+			if err = o.csv.Write(o.header); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+		o.csv.Flush()
+		if err = o.csv.Error(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func nullUUID(id uuid.NullUUID) string {
+	if !id.Valid {
+		return ""
+	}
+	return id.UUID.String()
+}
+
+// parsePeriod reads the optional from and to query params.
+func parsePeriod(r *http.Request) (time.Time, time.Time, bool) {
+	from := time.Unix(0, 0)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+
+	// Far enough in the future to include every mail.
+	to := time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+
+	return from, to, !from.After(to)
+}
@@ -0,0 +1,118 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeExport struct {
+	storage.Export
+	users []model.UserExport
+	err   error
+}
+
+func (f *fakeExport) ExportUsers(_ context.Context, fn func(model.UserExport) error) error {
+	for _, user := range f.users {
+		if err := fn(user); err != nil {
+			return err
+		}
+	}
+	return f.err
+}
+
+func TestGetUsers(t *testing.T) {
+	users := []model.UserExport{
+		{User: model.User{ID: uuid.New(), Email: "a@example.com", FirstName: "Ann", LastName: "Lee"}, Groups: []string{"news"}},
+		{User: model.User{ID: uuid.New(), Email: "b@example.com", FirstName: "Bo, Jr.", LastName: "\"Quoted\""}},
+	}
+	failure := errors.New("connection reset")
+
+	tests := []struct {
+		name            string
+		format          string
+		users           []model.UserExport
+		err             error
+		wantStatus      int
+		wantContentType string
+		wantRecords     int
+	}{
+		{name: "default", users: users, wantStatus: http.StatusOK, wantContentType: "application/x-ndjson", wantRecords: 2},
+		{name: "ndjson", format: formatNdjson, users: users, wantStatus: http.StatusOK, wantContentType: "application/x-ndjson", wantRecords: 2},
+		{name: "csv", format: formatCsv, users: users, wantStatus: http.StatusOK, wantContentType: "text/csv", wantRecords: 2},
+		{name: "empty csv", format: formatCsv, wantStatus: http.StatusOK, wantContentType: "text/csv"},
+		{name: "empty ndjson", format: formatNdjson, wantStatus: http.StatusOK, wantContentType: "application/x-ndjson"},
+		{name: "failure before the first record", format: formatCsv, err: failure, wantStatus: http.StatusInternalServerError},
+		{name: "failure after a record", format: formatNdjson, users: users[:1], err: failure, wantStatus: http.StatusOK, wantContentType: "application/x-ndjson", wantRecords: 1},
+		{name: "unknown format", format: "xml", users: users, wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := chi.NewRouter()
+			NewExportHandlers(&fakeExport{users: tt.users, err: tt.err}).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users?format="+tt.format, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if w.Header().Get("Content-Disposition") != "" {
+					t.Errorf("Content-Disposition = %q on an error", w.Header().Get("Content-Disposition"))
+				}
+				return
+			}
+			if got := w.Header().Get("Content-Type"); got != tt.wantContentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.wantContentType)
+			}
+			if got := w.Header().Get("Content-Disposition"); !strings.HasPrefix(got, `attachment; filename="users.`) {
+				t.Errorf("Content-Disposition = %q", got)
+			}
+
+			if tt.wantContentType == "text/csv" {
+				records, err := csv.NewReader(w.Body).ReadAll()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(records) != tt.wantRecords+1 || !reflect.DeepEqual(records[0], userHeader) {
+					t.Fatalf("records = %q, want the header and %d records", records, tt.wantRecords)
+				}
+				for i, record := range records[1:] {
+					if record[0] != tt.users[i].ID.String() || record[1] != tt.users[i].Email || record[2] != tt.users[i].FirstName {
+						t.Errorf("record %d = %q, want %+v", i, record, tt.users[i])
+					}
+				}
+				return
+			}
+
+			var lines int
+			scanner := bufio.NewScanner(w.Body)
+			for scanner.Scan() {
+				var user model.UserExport
+				if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+					t.Fatalf("line %d = %s: %v", lines, scanner.Text(), err)
+				}
+				if user.ID != tt.users[lines].ID || user.Email != tt.users[lines].Email {
+					t.Errorf("line %d = %+v, want %+v", lines, user, tt.users[lines])
+				}
+				lines++
+			}
+			if lines != tt.wantRecords {
+				t.Errorf("lines = %d, want %d", lines, tt.wantRecords)
+			}
+		})
+	}
+}
@@ -7,33 +7,57 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"log"
+	"mail-service/internal/address"
 	"mail-service/internal/model"
+	"mail-service/internal/services/confirm"
 	"mail-service/internal/storage"
 	"mime"
 	"net/http"
+	"strconv"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
 )
 
 type GroupHandlers interface {
 	Register(r chi.Router)
 	PostCreateGroup(w http.ResponseWriter, r *http.Request)
 	GetGroupInfo(w http.ResponseWriter, r *http.Request)
+	GetGroups(w http.ResponseWriter, r *http.Request)
+	DeleteGroup(w http.ResponseWriter, r *http.Request)
+	GetMembers(w http.ResponseWriter, r *http.Request)
 	AddUserToGroup(w http.ResponseWriter, r *http.Request)
 	RemoveUserFromGroup(w http.ResponseWriter, r *http.Request)
+	AddUsersToGroup(w http.ResponseWriter, r *http.Request)
+	RemoveUsersFromGroup(w http.ResponseWriter, r *http.Request)
+	IncludeGroup(w http.ResponseWriter, r *http.Request)
+	RemoveIncludedGroup(w http.ResponseWriter, r *http.Request)
+	GetIncludedGroups(w http.ResponseWriter, r *http.Request)
 }
 
 type groupHandlers struct {
-	storage storage.Group
+	storage   storage.Group
+	confirmer confirm.Confirmer
 }
 
-func NewGroupHandlers(storage storage.Group) GroupHandlers {
-	return &groupHandlers{storage: storage}
+func NewGroupHandlers(storage storage.Group, confirmer confirm.Confirmer) GroupHandlers {
+	return &groupHandlers{storage: storage, confirmer: confirmer}
 }
 
 func (s *groupHandlers) Register(r chi.Router) {
 	r.Post("/", s.PostCreateGroup)
 	r.Get("/", s.GetGroupInfo)
+	r.Delete("/{group_id}", s.DeleteGroup)
+	r.Get("/{group_id}/members", s.GetMembers)
 	r.Post("/{group_id}/add/{user_id}", s.AddUserToGroup)
 	r.Post("/{group_id}/remove/{user_id}", s.RemoveUserFromGroup)
+	r.Post("/{group_id}/add", s.AddUsersToGroup)
+	r.Post("/{group_id}/remove", s.RemoveUsersFromGroup)
+	r.Get("/{group_id}/include", s.GetIncludedGroups)
+	r.Post("/{group_id}/include/{included_id}", s.IncludeGroup)
+	r.Delete("/{group_id}/include/{included_id}", s.RemoveIncludedGroup)
 }
 
 func (s *groupHandlers) PostCreateGroup(w http.ResponseWriter, r *http.Request) {
@@ -59,10 +83,13 @@ func (s *groupHandlers) PostCreateGroup(w http.ResponseWriter, r *http.Request)
 	}
 
 	id, err := s.storage.CreateGroup(r.Context(), group)
-	if err != nil {
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if errors.Is(err, storage.ErrConflict) {
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	_, err = w.Write([]byte(id.String()))
@@ -75,7 +102,7 @@ func (s *groupHandlers) GetGroupInfo(w http.ResponseWriter, r *http.Request) {
 	groupId := r.URL.Query().Get("id")
 	groupName := r.URL.Query().Get("name")
 	if groupId == "" && groupName == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		s.GetGroups(w, r)
 		return
 	}
 
@@ -109,6 +136,128 @@ func (s *groupHandlers) GetGroupInfo(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// GetGroups lists groups page by page with their member counts.
+func (s *groupHandlers) GetGroups(w http.ResponseWriter, r *http.Request) {
+	limit, ok := parseLimit(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	offset := 0
+	if v := r.URL.Query().Get("offset"); v != "" {
+		var err error
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	groups, err := s.storage.GetGroups(r.Context(), limit, offset)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(groups)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// DeleteGroup removes the group together with its memberships, the mails
+// sent to it are kept.
+func (s *groupHandlers) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.DeleteGroup(r.Context(), groupId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetMembers lists the group members page by page, the cursor query param
+// continues the listing from the next_cursor of the previous page.
+func (s *groupHandlers) GetMembers(w http.ResponseWriter, r *http.Request) {
+	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	limit, ok := parseLimit(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var after uuid.NullUUID
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if after.UUID, err = uuid.Parse(v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		after.Valid = true
+	}
+
+	_, err = s.storage.GetGroupById(r.Context(), groupId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// One more member is fetched to know whether there is a next page.
+	users, err := s.storage.GetUsersByGroup(r.Context(), groupId, after, limit+1)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	page := model.MemberPage{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		page.NextCursor = page.Users[limit-1].ID.String()
+	}
+
+	err = json.NewEncoder(w).Encode(page)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func parseLimit(r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("limit")
+	if v == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 1 || limit > maxLimit {
+		return 0, false
+	}
+	return limit, true
+}
+
 func (s *groupHandlers) AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
 	if err != nil {
@@ -124,6 +273,29 @@ func (s *groupHandlers) AddUserToGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	group, err := s.storage.GetGroupById(r.Context(), groupId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Members of double opt-in groups become active only after they confirm
+	// the subscription.
+	if group.DoubleOptIn {
+		err = s.confirmer.RequestConfirmation(r.Context(), userId, group)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	err = s.storage.AddUserToGroup(r.Context(), userId, groupId)
 	if err != nil {
 		log.Println(err)
@@ -158,3 +330,208 @@ func (s *groupHandlers) RemoveUserFromGroup(w http.ResponseWriter, r *http.Reque
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// AddUsersToGroup adds a batch of users to the group in one transaction and
+// reports the outcome for every user, adding a member again succeeds.
+func (s *groupHandlers) AddUsersToGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, change, ok := parseMembershipChange(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := s.storage.GetGroupById(r.Context(), groupId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Members of double opt-in groups have to confirm the subscription one by
+	// one.
+	if group.DoubleOptIn {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	results, err := s.storage.AddUsersToGroup(r.Context(), groupId, change)
+	writeMembershipResults(w, results, err)
+}
+
+// RemoveUsersFromGroup removes a batch of users from the group in one
+// transaction and reports the outcome for every user.
+func (s *groupHandlers) RemoveUsersFromGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, change, ok := parseMembershipChange(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := s.storage.RemoveUsersFromGroup(r.Context(), groupId, change)
+	writeMembershipResults(w, results, err)
+}
+
+func parseMembershipChange(w http.ResponseWriter, r *http.Request) (uuid.UUID, model.MembershipChange, bool) {
+	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, model.MembershipChange{}, false
+	}
+
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, model.MembershipChange{}, false
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return uuid.Nil, model.MembershipChange{}, false
+	}
+
+	var change model.MembershipChange
+	err = json.NewDecoder(r.Body).Decode(&change)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, model.MembershipChange{}, false
+	}
+	if err = change.Validate(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, model.MembershipChange{}, false
+	}
+
+	for i, email := range change.Emails {
+		change.Emails[i] = address.Normalize(email)
+	}
+
+	return groupId, change, true
+}
+
+func writeMembershipResults(w http.ResponseWriter, results []model.MembershipResult, err error) {
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(results)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// IncludeGroup makes the members of the included group members of the group
+// too, a group can't include itself, directly or through other groups. A
+// double opt-in group can only include double opt-in groups, so all of its
+// members have confirmed.
+func (s *groupHandlers) IncludeGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, includedId, ok := parseInclusion(w, r)
+	if !ok {
+		return
+	}
+
+	groups := make([]model.Group, 0, 2)
+	for _, id := range []uuid.UUID{groupId, includedId} {
+		group, err := s.storage.GetGroupById(r.Context(), id)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		groups = append(groups, group)
+	}
+	// double_opt_in can't be changed after a group is created, so checking
+	// the direct inclusion covers the included groups of the included group.
+	if groups[0].DoubleOptIn && !groups[1].DoubleOptIn {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	err := s.storage.IncludeGroup(r.Context(), groupId, includedId)
+	if err != nil && !errors.Is(err, storage.ErrCycle) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, storage.ErrCycle) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *groupHandlers) RemoveIncludedGroup(w http.ResponseWriter, r *http.Request) {
+	groupId, includedId, ok := parseInclusion(w, r)
+	if !ok {
+		return
+	}
+
+	err := s.storage.RemoveIncludedGroup(r.Context(), groupId, includedId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetIncludedGroups lists the groups the group includes directly.
+func (s *groupHandlers) GetIncludedGroups(w http.ResponseWriter, r *http.Request) {
+	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.storage.GetGroupById(r.Context(), groupId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	groups, err := s.storage.GetIncludedGroups(r.Context(), groupId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(groups)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func parseInclusion(w http.ResponseWriter, r *http.Request) (uuid.UUID, uuid.UUID, bool) {
+	groupId, err := uuid.Parse(chi.URLParam(r, "group_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	includedId, err := uuid.Parse(chi.URLParam(r, "included_id"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return uuid.Nil, uuid.Nil, false
+	}
+
+	return groupId, includedId, true
+}
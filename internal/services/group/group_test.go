@@ -0,0 +1,80 @@
+package group
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeGroups struct {
+	storage.Group
+	groups   map[uuid.UUID]model.Group
+	cycle    bool
+	included int
+}
+
+func (f *fakeGroups) GetGroupById(_ context.Context, id uuid.UUID) (model.Group, error) {
+	group, ok := f.groups[id]
+	if !ok {
+		return model.Group{}, sql.ErrNoRows
+	}
+	return group, nil
+}
+
+func (f *fakeGroups) IncludeGroup(_ context.Context, _, _ uuid.UUID) error {
+	if f.cycle {
+		return fmt.Errorf("can't include group: %w", storage.ErrCycle)
+	}
+	f.included++
+	return nil
+}
+
+func TestIncludeGroup(t *testing.T) {
+	single := model.Group{ID: uuid.New(), Name: "single"}
+	double := model.Group{ID: uuid.New(), Name: "double", DoubleOptIn: true}
+	otherDouble := model.Group{ID: uuid.New(), Name: "other double", DoubleOptIn: true}
+
+	tests := []struct {
+		name     string
+		group    uuid.UUID
+		included uuid.UUID
+		cycle    bool
+		want     int
+	}{
+		{name: "single includes single", group: single.ID, included: single.ID, want: http.StatusOK},
+		{name: "single includes double", group: single.ID, included: double.ID, want: http.StatusOK},
+		{name: "double includes double", group: double.ID, included: otherDouble.ID, want: http.StatusOK},
+		{name: "double includes single", group: double.ID, included: single.ID, want: http.StatusConflict},
+		{name: "cycle", group: double.ID, included: otherDouble.ID, cycle: true, want: http.StatusConflict},
+		{name: "unknown group", group: uuid.New(), included: single.ID, want: http.StatusNotFound},
+		{name: "unknown included group", group: single.ID, included: uuid.New(), want: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := &fakeGroups{
+				groups: map[uuid.UUID]model.Group{single.ID: single, double.ID: double, otherDouble.ID: otherDouble},
+				cycle:  tt.cycle,
+			}
+			r := chi.NewRouter()
+			NewGroupHandlers(groups, nil).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/%s/include/%s", tt.group, tt.included), nil))
+
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d", w.Code, tt.want)
+			}
+			if wantIncluded := tt.want == http.StatusOK; (groups.included == 1) != wantIncluded {
+				t.Errorf("included = %d, want included %v", groups.included, wantIncluded)
+			}
+		})
+	}
+}
@@ -1,61 +1,101 @@
 package img
 
 import (
+	_ "embed"
 	"github.com/go-chi/chi/v5"
-	"github.com/google/uuid"
-	"io"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/services/client"
 	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/webhook"
 	"net/http"
-	"os"
+	"path"
+	"strconv"
+	"strings"
 )
 
+//go:embed pixel.gif
+var pixel []byte
+
 type ImageHandlers interface {
 	Register(r chi.Router)
 	GetImage(w http.ResponseWriter, r *http.Request)
 }
 
 type imageHandlers struct {
-	mails storage.Mail
+	tracking storage.Tracking
+	signer   *token.Signer
+	events   webhook.Emitter
+	proxies  client.Proxies
+
+	flagAutomated bool
 }
 
-func NewImageHandlers(mails storage.Mail) ImageHandlers {
-	return &imageHandlers{mails: mails}
+// NewImageHandlers creates the tracking pixel handlers. When flagAutomated is
+// set, opens made by mailbox proxies and prefetchers are recorded as automated
+// and don't count as the recipient opening the mail.
+func NewImageHandlers(tracking storage.Tracking, signer *token.Signer, events webhook.Emitter, proxies client.Proxies, flagAutomated bool) ImageHandlers {
+	return &imageHandlers{tracking: tracking, signer: signer, events: events, proxies: proxies, flagAutomated: flagAutomated}
 }
 
 func (s *imageHandlers) Register(r chi.Router) {
-	r.Get("/{mail_id}.png", s.GetImage)
+	// Tokens contain dots, so the extension is split off in the handler, a
+	// "/{token}.gif" pattern would stop the token at its first dot.
+	r.Get("/{file}", s.GetImage)
 }
 
+// GetImage always responds with the pixel, so a forged or expired token
+// can't be told apart from a valid one.
 func (s *imageHandlers) GetImage(w http.ResponseWriter, r *http.Request) {
-	mailId := chi.URLParam(r, "mail_id")
-	id, err := uuid.Parse(mailId)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	file := chi.URLParam(r, "file")
+	ext := path.Ext(file)
+	if ext != ".gif" && ext != ".png" {
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 
-	err = s.mails.MarkAsWatched(r.Context(), id)
-	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		return
-	}
-	file, err := os.Open("templates/img.png")
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
-	}
-	defer func(file *os.File) {
-		err := file.Close()
+	id, err := s.signer.Verify(token.Open, strings.TrimSuffix(file, ext))
+	if err == nil {
+		event := model.OpenEvent{
+			MailID:    id,
+			IP:        s.proxies.IP(r),
+			UserAgent: r.UserAgent(),
+			Automated: s.flagAutomated && isAutomated(r.UserAgent()),
+		}
+		err = s.tracking.RecordOpen(r.Context(), event)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return
+			log.Println(err)
+		} else {
+			s.events.Emit(webhook.Event{Type: model.EventMailOpened, MailID: id, Automated: event.Automated})
 		}
-	}(file)
-	w.Header().Set("Content-Type", "application/octet-stream")
+	}
+
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Content-Length", strconv.Itoa(len(pixel)))
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Expires", "0")
 
-	_, err = io.Copy(w, file)
+	_, err = w.Write(pixel)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		log.Println(err)
+	}
+}
+
+// isAutomated reports whether the pixel was fetched by a mailbox proxy or a
+// prefetcher rather than by the recipient's mail client.
+func isAutomated(userAgent string) bool {
+	// Apple Mail Privacy Protection prefetches remote content with a bare
+	// "Mozilla/5.0" User-Agent.
+	if userAgent == "Mozilla/5.0" {
+		return true
+	}
+
+	for _, proxy := range []string{"GoogleImageProxy", "YahooMailProxy", "Yahoo! Slurp"} {
+		if strings.Contains(userAgent, proxy) {
+			return true
+		}
 	}
+	return false
 }
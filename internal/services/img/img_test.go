@@ -0,0 +1,148 @@
+package img
+
+import (
+	"bytes"
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/webhook"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeTracking struct {
+	storage.Tracking
+	opens []model.OpenEvent
+}
+
+func (f *fakeTracking) RecordOpen(_ context.Context, event model.OpenEvent) error {
+	f.opens = append(f.opens, event)
+	return nil
+}
+
+type fakeEmitter struct {
+	events []webhook.Event
+}
+
+func (f *fakeEmitter) Emit(event webhook.Event) {
+	f.events = append(f.events, event)
+}
+
+func TestGetImage(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailID := uuid.New()
+
+	tests := []struct {
+		name          string
+		path          string
+		userAgent     string
+		flagAutomated bool
+		wantOpen      bool
+		wantAutomated bool
+	}{
+		{name: "gif", path: "/" + signer.Sign(token.Open, mailID) + ".gif", userAgent: "Thunderbird", wantOpen: true},
+		{name: "png", path: "/" + signer.Sign(token.Open, mailID) + ".png", userAgent: "Thunderbird", wantOpen: true},
+		{name: "automated", path: "/" + signer.Sign(token.Open, mailID) + ".gif", userAgent: "Mozilla/5.0", flagAutomated: true, wantOpen: true, wantAutomated: true},
+		{name: "automated not flagged", path: "/" + signer.Sign(token.Open, mailID) + ".gif", userAgent: "Mozilla/5.0", wantOpen: true},
+		{name: "other purpose", path: "/" + signer.Sign(token.Click, mailID) + ".gif"},
+		{name: "invalid token", path: "/k1.x.y.gif"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracking := &fakeTracking{}
+			events := &fakeEmitter{}
+			r := chi.NewRouter()
+			NewImageHandlers(tracking, signer, events, nil, tt.flagAutomated).Register(r)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Header.Set("User-Agent", tt.userAgent)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", w.Code, http.StatusOK)
+			}
+			if !bytes.Equal(w.Body.Bytes(), pixel) {
+				t.Errorf("body isn't the pixel")
+			}
+			for header, want := range map[string]string{
+				"Content-Type":  "image/gif",
+				"Cache-Control": "no-cache, no-store, must-revalidate, max-age=0",
+				"Pragma":        "no-cache",
+				"Expires":       "0",
+			} {
+				if got := w.Header().Get(header); got != want {
+					t.Errorf("%s = %q, want %q", header, got, want)
+				}
+			}
+
+			if !tt.wantOpen {
+				if len(tracking.opens) != 0 || len(events.events) != 0 {
+					t.Errorf("opens = %v, events = %v, want none", tracking.opens, events.events)
+				}
+				return
+			}
+			if len(tracking.opens) != 1 || len(events.events) != 1 {
+				t.Fatalf("opens = %v, events = %v, want one", tracking.opens, events.events)
+			}
+			open := tracking.opens[0]
+			if open.MailID != mailID || open.UserAgent != tt.userAgent || open.Automated != tt.wantAutomated {
+				t.Errorf("open = %+v, want mail %s from %q, automated %v", open, mailID, tt.userAgent, tt.wantAutomated)
+			}
+			if event := events.events[0]; event.Type != model.EventMailOpened || event.Automated != tt.wantAutomated {
+				t.Errorf("event = %+v", event)
+			}
+		})
+	}
+}
+
+func TestGetImageUnknownExtension(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracking := &fakeTracking{}
+	r := chi.NewRouter()
+	NewImageHandlers(tracking, signer, &fakeEmitter{}, nil, false).Register(r)
+
+	for _, path := range []string{"/" + signer.Sign(token.Open, uuid.New()) + ".jpg", "/" + signer.Sign(token.Open, uuid.New())} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("%s: status = %d, want %d", path, w.Code, http.StatusNotFound)
+		}
+	}
+	if len(tracking.opens) != 0 {
+		t.Errorf("opens = %v, want none", tracking.opens)
+	}
+}
+
+func TestIsAutomated(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      bool
+	}{
+		{userAgent: "Mozilla/5.0", want: true},
+		{userAgent: "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)", want: true},
+		{userAgent: "YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", want: true},
+		{userAgent: "Mozilla/5.0 (compatible; Yahoo! Slurp; http://help.yahoo.com/help/us/ysearch/slurp)", want: true},
+		{userAgent: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko)", want: false},
+		{userAgent: "Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:115.0) Gecko/20100101 Thunderbird/115.3.1", want: false},
+		{userAgent: "", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := isAutomated(tt.userAgent); got != tt.want {
+			t.Errorf("isAutomated(%q) = %v, want %v", tt.userAgent, got, tt.want)
+		}
+	}
+}
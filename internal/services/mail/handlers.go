@@ -9,6 +9,8 @@ import (
 	"github.com/google/uuid"
 	"log"
 	"mail-service/internal/model"
+	"mail-service/internal/schedule"
+	"mail-service/internal/segment"
 	"mail-service/internal/storage"
 	"net/http"
 	"time"
@@ -18,25 +20,32 @@ type MailHandlers interface {
 	Register(r chi.Router)
 	SendMailToUser(w http.ResponseWriter, r *http.Request)
 	SendMailToGroup(w http.ResponseWriter, r *http.Request)
+	SendMailToSegment(w http.ResponseWriter, r *http.Request)
 	GetMailsSentToUser(w http.ResponseWriter, r *http.Request)
 	GetMailById(w http.ResponseWriter, r *http.Request)
+	GetMailEvents(w http.ResponseWriter, r *http.Request)
+	GetMailClicks(w http.ResponseWriter, r *http.Request)
 }
 
 type mailHandlers struct {
-	groups storage.Group
-	users  storage.User
-	sender Sender
+	groups   storage.Group
+	users    storage.User
+	segments storage.Segment
+	sender   Sender
 }
 
-func NewMailHandlers(groups storage.Group, users storage.User, sender Sender) MailHandlers {
-	return &mailHandlers{groups: groups, users: users, sender: sender}
+func NewMailHandlers(groups storage.Group, users storage.User, segments storage.Segment, sender Sender) MailHandlers {
+	return &mailHandlers{groups: groups, users: users, segments: segments, sender: sender}
 }
 
 func (s *mailHandlers) Register(r chi.Router) {
 	r.Post("/to/user/{user_id}", s.SendMailToUser)
 	r.Post("/to/group/{group_id}", s.SendMailToGroup)
+	r.Post("/to/segment/{segment_id}", s.SendMailToSegment)
 	r.Get("/to/user/{user_id}", s.GetMailsSentToUser)
 	r.Get("/{mail_id}", s.GetMailById)
+	r.Get("/{mail_id}/events", s.GetMailEvents)
+	r.Get("/{mail_id}/clicks", s.GetMailClicks)
 }
 
 func (s *mailHandlers) SendMailToUser(w http.ResponseWriter, r *http.Request) {
@@ -66,7 +75,7 @@ func (s *mailHandlers) SendMailToUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = s.sendToUser(r.Context(), mail, user)
+	err = s.sendToUser(r.Context(), mail, user, model.Mail{})
 	if err != nil {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -95,7 +104,7 @@ func (s *mailHandlers) SendMailToGroup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	users, err := s.groups.GetUsersByGroup(r.Context(), id)
+	users, err := s.groups.GetRecipientsByGroup(r.Context(), id, mail.ExcludeGroups)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -105,7 +114,7 @@ func (s *mailHandlers) SendMailToGroup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for _, user := range users {
-		err = s.sendToUser(r.Context(), mail, user)
+		err = s.sendToUser(r.Context(), mail, user, model.Mail{GroupId: uuid.NullUUID{UUID: id, Valid: true}})
 		if err != nil {
 			log.Println(err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -114,26 +123,110 @@ func (s *mailHandlers) SendMailToGroup(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func (s *mailHandlers) sendToUser(ctx context.Context, mail model.MailJson, user model.User) error {
-	if mail.SendAt == "" {
-		return s.sender.CreateAndSend(ctx, model.Mail{
-			ToUserId: user.ID,
-			Subject:  mail.Subject,
-			Body:     mail.Body,
-		})
+// SendMailToSegment sends the mail to the users matching the segment at the
+// time of the request.
+func (s *mailHandlers) SendMailToSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "segment_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var mail model.MailJson
+	err = json.NewDecoder(r.Body).Decode(&mail)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = mail.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	seg, err := s.segments.GetSegment(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	expr, err := segment.Parse(seg.Expression)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	users, err := s.segments.GetUsersBySegment(r.Context(), expr)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for _, user := range users {
+		err = s.sendToUser(r.Context(), mail, user, model.Mail{SegmentId: uuid.NullUUID{UUID: id, Valid: true}})
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+}
+
+// sendToUser sends the mail to the user, the target holds the group or the
+// segment the mail is sent to.
+func (s *mailHandlers) sendToUser(ctx context.Context, mail model.MailJson, user model.User, target model.Mail) error {
+	tmpl := mail.Template
+	if tmpl == "" {
+		tmpl = model.DefaultTemplate
+	}
+	priority := mail.Priority
+	if priority == "" {
+		priority = model.PriorityNormal
+	}
+
+	m := model.Mail{
+		ToUserId:  user.ID,
+		GroupId:   target.GroupId,
+		SegmentId: target.SegmentId,
+		Template:  tmpl,
+		Priority:  priority,
+		Category:  mail.Category,
+		Subject:   mail.Subject,
+		Body:      mail.Body,
+	}
+
+	if mail.SendAt == "" && mail.SendAtLocal == "" {
+		return s.sender.CreateAndSend(ctx, m)
 	} else {
-		parse, err := time.Parse(time.RFC3339, mail.SendAt)
+		parse, err := sendAt(mail, user)
 		if err != nil {
 			return err
 		}
-		return s.sender.CreateDelayedMail(ctx, model.Mail{
-			ToUserId: user.ID,
-			Subject:  mail.Subject,
-			Body:     mail.Body,
-		}, parse)
+		return s.sender.CreateDelayedMail(ctx, m, parse)
 	}
 }
 
+// sendAt returns the instant the mail has to be sent at, a local send time
+// is taken in the user's time zone.
+func sendAt(mail model.MailJson, user model.User) (time.Time, error) {
+	if mail.SendAtLocal == "" {
+		return time.Parse(time.RFC3339, mail.SendAt)
+	}
+
+	wall, err := time.Parse(schedule.LocalLayout, mail.SendAtLocal)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedule.At(wall, schedule.Location(user.Timezone)), nil
+}
+
 func (s *mailHandlers) GetMailsSentToUser(w http.ResponseWriter, r *http.Request) {
 	userId := chi.URLParam(r, "user_id")
 	id, err := uuid.Parse(userId)
@@ -190,3 +283,65 @@ func (s *mailHandlers) GetMailById(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+func (s *mailHandlers) GetMailEvents(w http.ResponseWriter, r *http.Request) {
+	mailId := chi.URLParam(r, "mail_id")
+	id, err := uuid.Parse(mailId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.sender.GetMailById(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	events, err := s.sender.GetOpenEvents(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(events)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *mailHandlers) GetMailClicks(w http.ResponseWriter, r *http.Request) {
+	mailId := chi.URLParam(r, "mail_id")
+	id, err := uuid.Parse(mailId)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.sender.GetMailById(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	events, err := s.sender.GetClickEvents(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(events)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,63 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"html"
+	"mail-service/internal/model"
+	"mail-service/internal/token"
+	"regexp"
+	"strings"
+)
+
+// noTrackAttr can be put on an <a> tag in a template to keep its link
+// untouched by click tracking.
+const noTrackAttr = "data-notrack"
+
+var (
+	anchorRe  = regexp.MustCompile(`(?is)<a\s[^>]*>`)
+	hrefRe    = regexp.MustCompile(`(?is)(\shref\s*=\s*)("[^"]*"|'[^']*')`)
+	noTrackRe = regexp.MustCompile(`(?i)\s` + noTrackAttr + `(\s*=\s*("[^"]*"|'[^']*'|[^\s>]*))?`)
+)
+
+// trackLinks replaces every http(s) link in the mail body with a redirect
+// through the click tracking handler.
+func (m *Worker) trackLinks(ctx context.Context, mailId uuid.UUID, body string) (string, error) {
+	var err error
+
+	result := anchorRe.ReplaceAllStringFunc(body, func(tag string) string {
+		if err != nil {
+			return tag
+		}
+
+		if noTrackRe.MatchString(tag) {
+			return noTrackRe.ReplaceAllString(tag, "")
+		}
+
+		match := hrefRe.FindStringSubmatch(tag)
+		if match == nil {
+			return tag
+		}
+
+		url := html.UnescapeString(match[2][1 : len(match[2])-1])
+		lower := strings.ToLower(url)
+		if !strings.HasPrefix(lower, "http://") && !strings.HasPrefix(lower, "https://") {
+			return tag
+		}
+
+		var id uuid.UUID
+		id, err = m.tracking.CreateLink(ctx, model.Link{MailID: mailId, URL: url})
+		if err != nil {
+			return tag
+		}
+
+		redirect := fmt.Sprintf(`%s"%s/r/%s"`, match[1], m.host, m.signer.Sign(token.Click, id))
+		return strings.Replace(tag, match[0], redirect, 1)
+	})
+	if err != nil {
+		return "", fmt.Errorf("can't create link: %w", err)
+	}
+
+	return result, nil
+}
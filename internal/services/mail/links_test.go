@@ -0,0 +1,167 @@
+package mail
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"html/template"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testHost = "https://mail.example.com"
+
+var redirectRe = regexp.MustCompile(regexp.QuoteMeta(testHost) + `/r/([^"]+)`)
+
+func TestMain(m *testing.M) {
+	// Templates are loaded relative to the repository root.
+	err := os.Chdir("../../..")
+	if err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+type fakeTracking struct {
+	storage.Tracking
+	links map[uuid.UUID]model.Link
+}
+
+func (f *fakeTracking) CreateLink(_ context.Context, link model.Link) (uuid.UUID, error) {
+	link.ID = uuid.New()
+	f.links[link.ID] = link
+	return link.ID, nil
+}
+
+func newLinkWorker(t *testing.T) (*Worker, *fakeTracking) {
+	t.Helper()
+
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracking := &fakeTracking{links: map[uuid.UUID]model.Link{}}
+	return &Worker{host: testHost, signer: signer, tracking: tracking}, tracking
+}
+
+// resolveLinks checks that every redirect in body verifies as a click token
+// of a link created for mailId, and returns the body with the redirects
+// replaced by LINK and the URLs they lead to.
+func resolveLinks(t *testing.T, w *Worker, tracking *fakeTracking, mailId uuid.UUID, body string) (string, []string) {
+	t.Helper()
+
+	var urls []string
+	result := redirectRe.ReplaceAllStringFunc(body, func(redirect string) string {
+		id, err := w.signer.Verify(token.Click, redirectRe.FindStringSubmatch(redirect)[1])
+		if err != nil {
+			t.Errorf("redirect %q doesn't verify: %v", redirect, err)
+			return redirect
+		}
+		link, ok := tracking.links[id]
+		if !ok {
+			t.Errorf("redirect %q leads to an unknown link", redirect)
+			return redirect
+		}
+		if link.MailID != mailId {
+			t.Errorf("link mail = %v, want %v", link.MailID, mailId)
+		}
+		urls = append(urls, link.URL)
+		return "LINK"
+	})
+	return result, urls
+}
+
+func TestTrackLinks(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		want     string
+		wantURLs []string
+	}{
+		{
+			name:     "http and https",
+			body:     `<a href="http://example.com/a">a</a> <a class="x" href='https://example.com/b'>b</a>`,
+			want:     `<a href="LINK">a</a> <a class="x" href="LINK">b</a>`,
+			wantURLs: []string{"http://example.com/a", "https://example.com/b"},
+		},
+		{
+			name:     "escaped url",
+			body:     `<A HREF="https://example.com/?a=1&amp;b=2">x</A>`,
+			want:     `<A HREF="LINK">x</A>`,
+			wantURLs: []string{"https://example.com/?a=1&b=2"},
+		},
+		{
+			name: "notrack",
+			body: `<a href="https://example.com" data-notrack>x</a> <a data-notrack="true" href="https://example.com">y</a>`,
+			want: `<a href="https://example.com">x</a> <a href="https://example.com">y</a>`,
+		},
+		{
+			name: "other schemes",
+			body: `<a href="mailto:a@example.com">a</a> <a href="/relative">b</a> <a name="top">c</a>`,
+			want: `<a href="mailto:a@example.com">a</a> <a href="/relative">b</a> <a name="top">c</a>`,
+		},
+		{
+			name: "plain text",
+			body: `see https://example.com`,
+			want: `see https://example.com`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, tracking := newLinkWorker(t)
+			mailId := uuid.New()
+
+			body, err := w.trackLinks(context.Background(), mailId, tt.body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, urls := resolveLinks(t, w, tracking, mailId, body)
+			if got != tt.want {
+				t.Errorf("body = %s, want %s", got, tt.want)
+			}
+			if !reflect.DeepEqual(urls, tt.wantURLs) {
+				t.Errorf("urls = %v, want %v", urls, tt.wantURLs)
+			}
+			if len(tracking.links) != len(tt.wantURLs) {
+				t.Errorf("created %d links, want %d", len(tracking.links), len(tt.wantURLs))
+			}
+		})
+	}
+}
+
+func TestTrackedBodyInTemplate(t *testing.T) {
+	w, tracking := newLinkWorker(t)
+	mailId := uuid.New()
+
+	body, err := w.trackLinks(context.Background(), mailId, `<a href="https://example.com">x</a>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	html, err := buildHtml(model.DefaultTemplate, templateData{
+		Body:           template.HTML(body),
+		PreferencesUrl: testHost + "/preferences/token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, urls := resolveLinks(t, w, tracking, mailId, html.String())
+	if !strings.Contains(got, `<a href="LINK">x</a>`) {
+		t.Errorf("body link isn't tracked in %s", got)
+	}
+	if !reflect.DeepEqual(urls, []string{"https://example.com"}) {
+		t.Errorf("urls = %v, want [https://example.com]", urls)
+	}
+	if !strings.Contains(got, `<a href="`+testHost+`/preferences/token" data-notrack>`) {
+		t.Errorf("template link is changed in %s", got)
+	}
+}
@@ -11,6 +11,9 @@ import (
 	"mail-service/internal/model"
 	"mail-service/internal/queue"
 	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/verp"
+	"mail-service/internal/webhook"
 	"time"
 )
 
@@ -19,27 +22,39 @@ type Sender interface {
 	CreateDelayedMail(ctx context.Context, mail model.Mail, delay time.Time) error
 	GetMailsBySentTo(ctx context.Context, userId uuid.UUID) ([]model.Mail, error)
 	GetMailById(ctx context.Context, id uuid.UUID) (model.Mail, error)
+	GetOpenEvents(ctx context.Context, mailId uuid.UUID) ([]model.OpenEvent, error)
+	GetClickEvents(ctx context.Context, mailId uuid.UUID) ([]model.ClickEvent, error)
 }
 
 type SmtpConfig struct {
 	Addr     string
 	Username string
 	Password string
+
+	// ReturnPaths makes a per-mail envelope sender, the username is used if
+	// it is nil.
+	ReturnPaths *verp.Encoder
 }
 
 type Worker struct {
-	smtpClient *smtp.Client
-	author     string
+	smtpClient  *smtp.Client
+	author      string
+	returnPaths *verp.Encoder
 
-	mails storage.Mail
-	users storage.User
+	mails        storage.Mail
+	users        storage.User
+	tracking     storage.Tracking
+	suppressions storage.Suppression
 
-	queue queue.DelayedQueue
+	queue  queue.DelayedQueue
+	policy Policy
 
-	host string
+	host   string
+	signer *token.Signer
+	events webhook.Emitter
 }
 
-func NewWorker(host string, smtpConfig SmtpConfig, mails storage.Mail, users storage.User, q queue.DelayedQueue) (*Worker, error) {
+func NewWorker(host string, smtpConfig SmtpConfig, mails storage.Mail, users storage.User, tracking storage.Tracking, suppressions storage.Suppression, q queue.DelayedQueue, policy Policy, signer *token.Signer, events webhook.Emitter) (*Worker, error) {
 	cl, err := smtp.Dial(smtpConfig.Addr)
 	if err != nil {
 		return nil, fmt.Errorf("can't dial: %w", err)
@@ -57,12 +72,18 @@ func NewWorker(host string, smtpConfig SmtpConfig, mails storage.Mail, users sto
 	}
 
 	return &Worker{
-		smtpClient: cl,
-		author:     smtpConfig.Username,
-		mails:      mails,
-		users:      users,
-		queue:      q,
-		host:       host,
+		smtpClient:   cl,
+		author:       smtpConfig.Username,
+		returnPaths:  smtpConfig.ReturnPaths,
+		mails:        mails,
+		users:        users,
+		tracking:     tracking,
+		suppressions: suppressions,
+		queue:        q,
+		policy:       policy,
+		host:         host,
+		signer:       signer,
+		events:       events,
 	}, nil
 }
 
@@ -75,6 +96,7 @@ func (m *Worker) Close() error {
 }
 
 func (m *Worker) CreateAndSend(ctx context.Context, mail model.Mail) error {
+	mail = withDefaults(mail)
 	id, err := m.mails.CreateMail(ctx, mail)
 	if err != nil {
 		return fmt.Errorf("can't create mail: %w", err)
@@ -87,32 +109,80 @@ func (m *Worker) CreateAndSend(ctx context.Context, mail model.Mail) error {
 		return fmt.Errorf("can't get user: %w", err)
 	}
 
+	suppressed, err := m.suppress(ctx, mail.ID, user)
+	if err != nil || suppressed {
+		return err
+	}
+
+	held, err := m.hold(ctx, mail, user)
+	if err != nil || held {
+		return err
+	}
+
 	err = m.Send(user, mail)
 	if err != nil {
+		m.markFailed(mail.ID, err)
 		return fmt.Errorf("can't send mail: %w", err)
 	}
 
 	return nil
 }
 
-func buildHtml(host string, user model.User, mail model.Mail) (bytes.Buffer, error) {
-	tmpl, err := template.ParseFiles("templates/template.html")
+// withDefaults fills in the priority of mails created without one, only the
+// mail handlers set it for every mail.
+func withDefaults(mail model.Mail) model.Mail {
+	if mail.Priority == "" {
+		mail.Priority = model.PriorityNormal
+	}
+	return mail
+}
+
+// suppress marks the mail as suppressed if the user's address is on the
+// suppression list and reports whether it has to be skipped.
+func (m *Worker) suppress(ctx context.Context, id uuid.UUID, user model.User) (bool, error) {
+	suppressed, err := m.suppressions.IsSuppressed(ctx, user.Email)
+	if err != nil {
+		return false, fmt.Errorf("can't check suppression: %w", err)
+	}
+	if !suppressed {
+		return false, nil
+	}
+
+	err = m.mails.MarkAsSuppressed(ctx, id)
+	if err != nil {
+		return true, fmt.Errorf("can't mark mail as suppressed: %w", err)
+	}
+	return true, nil
+}
+
+func (m *Worker) markFailed(id uuid.UUID, reason error) {
+	err := m.mails.MarkAsFailed(context.Background(), id, reason.Error())
+	if err != nil {
+		fmt.Printf("can't mark mail as failed: %v", err)
+	}
+
+	m.events.Emit(webhook.Event{Type: model.EventMailFailed, MailID: id, Error: reason.Error()})
+}
+
+// templateData holds the values available in mail templates.
+type templateData struct {
+	FirstName      string
+	LastName       string
+	Body           template.HTML
+	ImgUrl         string
+	UnsubscribeUrl string
+	PreferencesUrl string
+	Attr           model.Attributes
+}
+
+func buildHtml(name string, data templateData) (bytes.Buffer, error) {
+	tmpl, err := template.ParseFiles(fmt.Sprintf("templates/%s.html", name))
 	if err != nil {
 		return bytes.Buffer{}, fmt.Errorf("can't parse template: %w", err)
 	}
 
 	var b bytes.Buffer
-	err = tmpl.Execute(&b, struct {
-		FirstName string
-		LastName  string
-		Body      string
-		ImgUrl    string
-	}{
-		FirstName: user.FirstName,
-		LastName:  user.LastName,
-		Body:      mail.Body,
-		ImgUrl:    fmt.Sprintf("%s/img/%s.png", host, mail.ID.String()),
-	})
+	err = tmpl.Execute(&b, data)
 	if err != nil {
 		return bytes.Buffer{}, fmt.Errorf("can't execute template: %w", err)
 	}
@@ -121,9 +191,48 @@ func buildHtml(host string, user model.User, mail model.Mail) (bytes.Buffer, err
 }
 
 func (m *Worker) Send(user model.User, mail model.Mail) error {
-	err := m.smtpClient.Mail(m.author, nil)
+	// The body is HTML written by the sender, its links are tracked before it
+	// goes into the template so the template itself isn't rewritten.
+	body, err := m.trackLinks(context.Background(), mail.ID, mail.Body)
+	if err != nil {
+		return fmt.Errorf("can't track links: %w", err)
+	}
+
+	data := templateData{
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Body:      template.HTML(body),
+		Attr:      user.Attributes,
+		ImgUrl:    fmt.Sprintf("%s/img/%s.gif", m.host, m.signer.Sign(token.Open, mail.ID)),
+
+		PreferencesUrl: fmt.Sprintf("%s/preferences/%s", m.host, m.signer.Sign(token.Preferences, user.ID)),
+	}
+
+	// Only group and segment mails are bulk mails which can be unsubscribed
+	// from, a segment mail has no group so its one-click unsubscribe is from
+	// all mails.
+	var headers string
+	if mail.GroupId.Valid || mail.SegmentId.Valid {
+		data.UnsubscribeUrl = fmt.Sprintf("%s/unsubscribe/%s", m.host, m.signer.Sign(token.Unsubscribe, mail.ID))
+		headers = fmt.Sprintf(
+			"List-Unsubscribe: <%s>\nList-Unsubscribe-Post: List-Unsubscribe=One-Click\n",
+			data.UnsubscribeUrl,
+		)
+	}
+
+	html, err := buildHtml(mail.Template, data)
+	if err != nil {
+		return fmt.Errorf("can't build html: %w", err)
+	}
+
+	sender := m.author
+	if m.returnPaths != nil {
+		sender = m.returnPaths.Address(mail.ID)
+	}
+
+	err = m.smtpClient.Mail(sender, nil)
 	if err != nil {
-		return fmt.Errorf("can't set author: %w", err)
+		return fmt.Errorf("can't set sender: %w", err)
 	}
 
 	err = m.smtpClient.Rcpt(user.Email)
@@ -137,9 +246,7 @@ func (m *Worker) Send(user model.User, mail model.Mail) error {
 	}
 
 	mime := "MIME-version: 1.0;\nContent-Type: text/html; charset=\"UTF-8\";\n\n"
-	body, err := buildHtml(m.host, user, mail)
-
-	_, err = fmt.Fprintf(wc, "Subject: %s\n%s\n%s\n", mail.Subject, mime, body.String())
+	_, err = fmt.Fprintf(wc, "From: %s\nSubject: %s\n%s%s\n%s\n", m.author, mail.Subject, headers, mime, html.String())
 	if err != nil {
 		return fmt.Errorf("can't write message: %w", err)
 	}
@@ -154,14 +261,28 @@ func (m *Worker) Send(user model.User, mail model.Mail) error {
 		fmt.Printf("can't mark mail as sent: %v", err)
 	}
 
+	m.events.Emit(webhook.Event{Type: model.EventMailSent, MailID: mail.ID})
+
 	return nil
 }
 
 func (m *Worker) CreateDelayedMail(ctx context.Context, mail model.Mail, delay time.Time) error {
+	mail = withDefaults(mail)
 	id, err := m.mails.CreateMail(ctx, mail)
 	if err != nil {
 		return fmt.Errorf("can't create mail: %w", err)
 	}
+
+	user, err := m.users.GetUser(ctx, mail.ToUserId)
+	if err != nil {
+		return fmt.Errorf("can't get user: %w", err)
+	}
+
+	suppressed, err := m.suppress(ctx, id, user)
+	if err != nil || suppressed {
+		return err
+	}
+
 	err = m.queue.Enqueue(ctx, queue.Mail{ID: id}, delay.Unix())
 	if err != nil {
 		return fmt.Errorf("can't enqueue mail: %w", err)
@@ -185,8 +306,25 @@ func (m *Worker) Run() {
 				fmt.Printf("can't get user: %v", err)
 				continue
 			}
+			suppressed, err := m.suppress(context.Background(), mail.ID, user)
+			if err != nil {
+				fmt.Printf("can't check suppression: %v", err)
+				continue
+			}
+			if suppressed {
+				continue
+			}
+			held, err := m.hold(context.Background(), mail, user)
+			if err != nil {
+				fmt.Printf("can't check policy: %v", err)
+				continue
+			}
+			if held {
+				continue
+			}
 			err = m.Send(user, mail)
 			if err != nil {
+				m.markFailed(mail.ID, err)
 				fmt.Printf("can't send mail: %v", err)
 				continue
 			}
@@ -201,3 +339,11 @@ func (m *Worker) GetMailsBySentTo(ctx context.Context, userId uuid.UUID) ([]mode
 func (m *Worker) GetMailById(ctx context.Context, id uuid.UUID) (model.Mail, error) {
 	return m.mails.GetMailById(ctx, id)
 }
+
+func (m *Worker) GetOpenEvents(ctx context.Context, mailId uuid.UUID) ([]model.OpenEvent, error) {
+	return m.tracking.GetOpenEvents(ctx, mailId)
+}
+
+func (m *Worker) GetClickEvents(ctx context.Context, mailId uuid.UUID) ([]model.ClickEvent, error) {
+	return m.tracking.GetClickEvents(ctx, mailId)
+}
@@ -0,0 +1,75 @@
+package mail
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/queue"
+	"mail-service/internal/storage"
+	"testing"
+	"time"
+)
+
+type fakeMails struct {
+	storage.Mail
+	created []model.Mail
+}
+
+func (f *fakeMails) CreateMail(_ context.Context, mail model.Mail) (uuid.UUID, error) {
+	f.created = append(f.created, mail)
+	return uuid.New(), nil
+}
+
+type fakeUsers struct {
+	storage.User
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, id uuid.UUID) (model.User, error) {
+	return model.User{ID: id, Email: "a@b.example", Timezone: "UTC"}, nil
+}
+
+type fakeSuppressions struct {
+	storage.Suppression
+}
+
+func (f *fakeSuppressions) IsSuppressed(_ context.Context, _ string) (bool, error) {
+	return false, nil
+}
+
+type fakeQueue struct {
+	queue.DelayedQueue
+	enqueued []queue.Mail
+}
+
+func (f *fakeQueue) Enqueue(_ context.Context, mail queue.Mail, _ int64) error {
+	f.enqueued = append(f.enqueued, mail)
+	return nil
+}
+
+func TestCreateDelayedMailPriority(t *testing.T) {
+	tests := []struct {
+		priority string
+		want     string
+	}{
+		{priority: "", want: model.PriorityNormal},
+		{priority: model.PriorityNormal, want: model.PriorityNormal},
+		{priority: model.PriorityUrgent, want: model.PriorityUrgent},
+	}
+
+	for _, tt := range tests {
+		mails := &fakeMails{}
+		q := &fakeQueue{}
+		w := &Worker{mails: mails, users: &fakeUsers{}, suppressions: &fakeSuppressions{}, queue: q}
+
+		err := w.CreateDelayedMail(context.Background(), model.Mail{ToUserId: uuid.New(), Priority: tt.priority}, time.Now().Add(time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mails.created) != 1 || len(q.enqueued) != 1 {
+			t.Fatalf("created = %v, enqueued = %v, want one", mails.created, q.enqueued)
+		}
+		if got := mails.created[0].Priority; got != tt.want {
+			t.Errorf("priority %q is stored as %q, want %q", tt.priority, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"mail-service/internal/model"
+	"mail-service/internal/queue"
+	"mail-service/internal/schedule"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy limits when and how often mails are sent to a user.
+type Policy struct {
+	// QuietHours are taken in the user's time zone, non-urgent mails which
+	// would be sent during them are deferred until they end.
+	QuietHours schedule.QuietHours
+
+	// Caps limits the number of mails of a category, mails over the cap are
+	// not sent.
+	Caps map[string]Cap
+}
+
+// Cap allows at most Max mails of the category to a user per Window.
+type Cap struct {
+	Category string
+	Max      int
+	Window   time.Duration
+}
+
+// ParseCap parses a cap in the form category:max/window, e.g.
+// marketing:3/168h.
+func ParseCap(s string) (Cap, error) {
+	category, limit, ok := strings.Cut(s, ":")
+	if !ok || category == "" {
+		return Cap{}, fmt.Errorf("invalid frequency cap %q", s)
+	}
+	max, window, ok := strings.Cut(limit, "/")
+	if !ok {
+		return Cap{}, fmt.Errorf("invalid frequency cap %q", s)
+	}
+
+	c := Cap{Category: category}
+	var err error
+	if c.Max, err = strconv.Atoi(max); err != nil || c.Max < 0 {
+		return Cap{}, fmt.Errorf("invalid frequency cap %q: bad max", s)
+	}
+	if c.Window, err = time.ParseDuration(window); err != nil || c.Window <= 0 {
+		return Cap{}, fmt.Errorf("invalid frequency cap %q: bad window", s)
+	}
+	return c, nil
+}
+
+// hold checks the mail against the policy before it is sent, a deferred mail
+// is put back to the queue and a capped one is dropped. It reports whether
+// the mail has to be skipped.
+func (m *Worker) hold(ctx context.Context, mail model.Mail, user model.User) (bool, error) {
+	if c, ok := m.policy.Caps[mail.Category]; ok && mail.Category != "" {
+		sent, err := m.mails.CountSentMails(ctx, user.ID, c.Category, time.Now().Add(-c.Window))
+		if err != nil {
+			return false, fmt.Errorf("can't count sent mails: %w", err)
+		}
+		if sent >= c.Max {
+			err = m.mails.MarkAsCapped(ctx, mail.ID)
+			if err != nil {
+				return true, fmt.Errorf("can't mark mail as capped: %w", err)
+			}
+			return true, nil
+		}
+	}
+
+	if mail.Priority == model.PriorityUrgent {
+		return false, nil
+	}
+
+	until, quiet := m.policy.QuietHours.Until(time.Now().In(schedule.Location(user.Timezone)))
+	if !quiet {
+		return false, nil
+	}
+
+	err := m.mails.MarkAsDeferred(ctx, mail.ID, until)
+	if err != nil {
+		return true, fmt.Errorf("can't mark mail as deferred: %w", err)
+	}
+	err = m.queue.Enqueue(ctx, queue.Mail{ID: mail.ID}, until.Unix())
+	if err != nil {
+		return true, fmt.Errorf("can't enqueue mail: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,35 @@
+package mail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCap(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    Cap
+		wantErr bool
+	}{
+		{s: "marketing:3/168h", want: Cap{Category: "marketing", Max: 3, Window: 168 * time.Hour}},
+		{s: "digest:0/24h", want: Cap{Category: "digest", Max: 0, Window: 24 * time.Hour}},
+		{s: "marketing:3", wantErr: true},
+		{s: "3/168h", wantErr: true},
+		{s: ":3/168h", wantErr: true},
+		{s: "marketing:-1/168h", wantErr: true},
+		{s: "marketing:x/168h", wantErr: true},
+		{s: "marketing:3/0s", wantErr: true},
+		{s: "marketing:3/week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCap(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseCap(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseCap(%q) = %+v, want %+v", tt.s, got, tt.want)
+		}
+	}
+}
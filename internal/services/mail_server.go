@@ -3,31 +3,64 @@ package services
 import (
 	"github.com/go-chi/chi/v5"
 	"log"
+	"mail-service/internal/services/attributes"
+	"mail-service/internal/services/complaint"
+	"mail-service/internal/services/confirm"
+	"mail-service/internal/services/export"
 	"mail-service/internal/services/group"
 	"mail-service/internal/services/img"
 	"mail-service/internal/services/mail"
+	"mail-service/internal/services/preferences"
+	"mail-service/internal/services/redirect"
+	"mail-service/internal/services/segments"
+	"mail-service/internal/services/stats"
+	"mail-service/internal/services/suppression"
+	"mail-service/internal/services/unsubscribe"
 	"mail-service/internal/services/user"
+	"mail-service/internal/services/webhooks"
 	"net/http"
 	"strconv"
 )
 
 type MailServer struct {
 	*http.Server
-	users  user.UserHandlers
-	groups group.GroupHandlers
-	mails  mail.MailHandlers
-	imgs   img.ImageHandlers
+	users     user.UserHandlers
+	attrs     attributes.AttributeHandlers
+	exports   export.ExportHandlers
+	groups    group.GroupHandlers
+	segments  segments.SegmentHandlers
+	mails     mail.MailHandlers
+	stats     stats.StatsHandlers
+	webhooks  webhooks.WebhookHandlers
+	suppress  suppression.SuppressionHandlers
+	complain  complaint.ComplaintHandlers
+	imgs      img.ImageHandlers
+	redirects redirect.RedirectHandlers
+	unsubs    unsubscribe.UnsubscribeHandlers
+	prefs     preferences.PreferenceHandlers
+	confirms  confirm.ConfirmHandlers
 }
 
-func NewMailServer(userServer user.UserHandlers, groupServer group.GroupHandlers, mails mail.MailHandlers, imgs img.ImageHandlers, port int) *MailServer {
+func NewMailServer(userServer user.UserHandlers, attrs attributes.AttributeHandlers, exports export.ExportHandlers, groupServer group.GroupHandlers, segments segments.SegmentHandlers, mails mail.MailHandlers, stats stats.StatsHandlers, webhooks webhooks.WebhookHandlers, suppress suppression.SuppressionHandlers, complain complaint.ComplaintHandlers, imgs img.ImageHandlers, redirects redirect.RedirectHandlers, unsubs unsubscribe.UnsubscribeHandlers, prefs preferences.PreferenceHandlers, confirms confirm.ConfirmHandlers, port int) *MailServer {
 	s := &MailServer{
 		Server: &http.Server{
 			Addr: ":" + strconv.Itoa(port),
 		},
-		users:  userServer,
-		groups: groupServer,
-		mails:  mails,
-		imgs:   imgs,
+		users:     userServer,
+		attrs:     attrs,
+		exports:   exports,
+		groups:    groupServer,
+		segments:  segments,
+		mails:     mails,
+		stats:     stats,
+		webhooks:  webhooks,
+		suppress:  suppress,
+		complain:  complain,
+		imgs:      imgs,
+		redirects: redirects,
+		unsubs:    unsubs,
+		prefs:     prefs,
+		confirms:  confirms,
 	}
 
 	r := chi.NewRouter()
@@ -40,9 +73,21 @@ func NewMailServer(userServer user.UserHandlers, groupServer group.GroupHandlers
 	})
 
 	r.Route("/api/v1/users", s.users.Register)
+	r.Route("/api/v1/attributes", s.attrs.Register)
+	r.Route("/api/v1/exports", s.exports.Register)
 	r.Route("/api/v1/groups", s.groups.Register)
+	r.Route("/api/v1/segments", s.segments.Register)
 	r.Route("/api/v1/mails", s.mails.Register)
+	r.Route("/api/v1/stats", s.stats.Register)
+	r.Route("/api/v1/webhooks", s.webhooks.Register)
+	r.Route("/api/v1/suppressions", s.suppress.Register)
+	r.Route("/api/v1/complaints", s.complain.Register)
+	r.Route("/api/v1/preferences", s.prefs.Register)
 	r.Route("/img", s.imgs.Register)
+	r.Route("/r", s.redirects.Register)
+	r.Route("/unsubscribe", s.unsubs.Register)
+	r.Route("/preferences", s.prefs.RegisterPages)
+	r.Route("/confirm", s.confirms.Register)
 
 	s.Handler = r
 	return s
@@ -0,0 +1,227 @@
+package preferences
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"html/template"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mime"
+	"net/http"
+)
+
+const (
+	sourcePage = "preference_page"
+	sourceApi  = "preference_api"
+)
+
+type PreferenceHandlers interface {
+	Register(r chi.Router)
+	RegisterPages(r chi.Router)
+	GetPreferences(w http.ResponseWriter, r *http.Request)
+	PutPreferences(w http.ResponseWriter, r *http.Request)
+	GetPreferencesPage(w http.ResponseWriter, r *http.Request)
+	PostPreferencesPage(w http.ResponseWriter, r *http.Request)
+}
+
+type preferenceHandlers struct {
+	users       storage.User
+	preferences storage.Preferences
+	signer      *token.Signer
+}
+
+func NewPreferenceHandlers(users storage.User, preferences storage.Preferences, signer *token.Signer) PreferenceHandlers {
+	return &preferenceHandlers{users: users, preferences: preferences, signer: signer}
+}
+
+// Register registers the JSON API.
+func (s *preferenceHandlers) Register(r chi.Router) {
+	r.Get("/{token}", s.GetPreferences)
+	r.Put("/{token}", s.PutPreferences)
+}
+
+// RegisterPages registers the preference center pages linked from mails.
+func (s *preferenceHandlers) RegisterPages(r chi.Router) {
+	r.Get("/{token}", s.GetPreferencesPage)
+	r.Post("/{token}", s.PostPreferencesPage)
+}
+
+func (s *preferenceHandlers) GetPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := s.signer.Verify(token.Preferences, chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	subscriptions, err := s.preferences.GetGroupSubscriptions(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(subscriptions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *preferenceHandlers) PutPreferences(w http.ResponseWriter, r *http.Request) {
+	userId, err := s.signer.Verify(token.Preferences, chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	contentType := r.Header.Get("Content-Type")
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var changes []model.GroupSubscription
+	err = json.NewDecoder(r.Body).Decode(&changes)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	desired := make(map[uuid.UUID]bool, len(changes))
+	for _, change := range changes {
+		desired[change.GroupID] = change.Subscribed
+	}
+
+	status := s.apply(r, userId, desired, sourceApi)
+	w.WriteHeader(status)
+}
+
+func (s *preferenceHandlers) GetPreferencesPage(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, r, false)
+}
+
+func (s *preferenceHandlers) PostPreferencesPage(w http.ResponseWriter, r *http.Request) {
+	userId, err := s.signer.Verify(token.Preferences, chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	checked := make(map[uuid.UUID]bool)
+	for _, v := range r.PostForm["group"] {
+		id, err := uuid.Parse(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		checked[id] = true
+	}
+
+	subscriptions, err := s.preferences.GetGroupSubscriptions(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	desired := make(map[uuid.UUID]bool, len(subscriptions))
+	for _, subscription := range subscriptions {
+		desired[subscription.GroupID] = checked[subscription.GroupID]
+	}
+
+	if status := s.apply(r, userId, desired, sourcePage); status != http.StatusOK {
+		w.WriteHeader(status)
+		return
+	}
+
+	s.renderPage(w, r, true)
+}
+
+// apply changes the subscriptions which differ from the desired state. Only
+// groups listed in the user's preferences can be changed.
+func (s *preferenceHandlers) apply(r *http.Request, userId uuid.UUID, desired map[uuid.UUID]bool, source string) int {
+	subscriptions, err := s.preferences.GetGroupSubscriptions(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		return http.StatusInternalServerError
+	}
+
+	current := make(map[uuid.UUID]bool, len(subscriptions))
+	for _, subscription := range subscriptions {
+		current[subscription.GroupID] = subscription.Subscribed
+	}
+
+	for groupId := range desired {
+		if _, ok := current[groupId]; !ok {
+			return http.StatusBadRequest
+		}
+	}
+
+	for groupId, subscribed := range desired {
+		if current[groupId] == subscribed {
+			continue
+		}
+		err = s.preferences.SetGroupSubscription(r.Context(), userId, groupId, subscribed, source)
+		if err != nil {
+			log.Println(err)
+			return http.StatusInternalServerError
+		}
+	}
+
+	return http.StatusOK
+}
+
+func (s *preferenceHandlers) renderPage(w http.ResponseWriter, r *http.Request, saved bool) {
+	userId, err := s.signer.Verify(token.Preferences, chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	user, err := s.users.GetUser(r.Context(), userId)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	subscriptions, err := s.preferences.GetGroupSubscriptions(r.Context(), userId)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/pages/preferences.html")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.Execute(w, struct {
+		Email         string
+		Subscriptions []model.GroupSubscription
+		Saved         bool
+	}{
+		Email:         user.Email,
+		Subscriptions: subscriptions,
+		Saved:         saved,
+	})
+	if err != nil {
+		log.Println(err)
+	}
+}
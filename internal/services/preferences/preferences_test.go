@@ -0,0 +1,113 @@
+package preferences
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type subscriptionChange struct {
+	GroupID    uuid.UUID
+	Subscribed bool
+	Source     string
+}
+
+type fakePreferences struct {
+	storage.Preferences
+	subscriptions []model.GroupSubscription
+	changes       []subscriptionChange
+}
+
+func (f *fakePreferences) GetGroupSubscriptions(_ context.Context, _ uuid.UUID) ([]model.GroupSubscription, error) {
+	return f.subscriptions, nil
+}
+
+func (f *fakePreferences) SetGroupSubscription(_ context.Context, _, groupID uuid.UUID, subscribed bool, source string) error {
+	f.changes = append(f.changes, subscriptionChange{groupID, subscribed, source})
+	return nil
+}
+
+func TestPutPreferences(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userID := uuid.New()
+	news, offers := uuid.New(), uuid.New()
+	subscriptions := []model.GroupSubscription{
+		{GroupID: news, Name: "news", Subscribed: true},
+		{GroupID: offers, Name: "offers", Subscribed: false},
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		body        string
+		wantStatus  int
+		wantChanges []subscriptionChange
+	}{
+		{
+			name:        "changes",
+			token:       signer.Sign(token.Preferences, userID),
+			body:        `[{"group_id":"` + news.String() + `","subscribed":false},{"group_id":"` + offers.String() + `","subscribed":true}]`,
+			wantStatus:  http.StatusOK,
+			wantChanges: []subscriptionChange{{news, false, sourceApi}, {offers, true, sourceApi}},
+		},
+		{
+			name:       "unchanged",
+			token:      signer.Sign(token.Preferences, userID),
+			body:       `[{"group_id":"` + news.String() + `","subscribed":true}]`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "unknown group",
+			token:      signer.Sign(token.Preferences, userID),
+			body:       `[{"group_id":"` + news.String() + `","subscribed":false},{"group_id":"` + uuid.NewString() + `","subscribed":true}]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "other purpose",
+			token:      signer.Sign(token.Unsubscribe, userID),
+			body:       `[{"group_id":"` + news.String() + `","subscribed":false}]`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preferences := &fakePreferences{subscriptions: subscriptions}
+			r := chi.NewRouter()
+			NewPreferenceHandlers(nil, preferences, signer).Register(r)
+
+			req := httptest.NewRequest(http.MethodPut, "/"+tt.token, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			// The changes are applied in map order.
+			got := map[uuid.UUID]subscriptionChange{}
+			for _, change := range preferences.changes {
+				got[change.GroupID] = change
+			}
+			want := map[uuid.UUID]subscriptionChange{}
+			for _, change := range tt.wantChanges {
+				want[change.GroupID] = change
+			}
+			if !reflect.DeepEqual(got, want) || len(preferences.changes) != len(tt.wantChanges) {
+				t.Errorf("changes = %+v, want %+v", preferences.changes, tt.wantChanges)
+			}
+		})
+	}
+}
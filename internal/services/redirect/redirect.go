@@ -0,0 +1,75 @@
+package redirect
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/services/client"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/webhook"
+	"net/http"
+)
+
+type RedirectHandlers interface {
+	Register(r chi.Router)
+	GetRedirect(w http.ResponseWriter, r *http.Request)
+}
+
+type redirectHandlers struct {
+	tracking storage.Tracking
+	signer   *token.Signer
+	events   webhook.Emitter
+	proxies  client.Proxies
+}
+
+func NewRedirectHandlers(tracking storage.Tracking, signer *token.Signer, events webhook.Emitter, proxies client.Proxies) RedirectHandlers {
+	return &redirectHandlers{tracking: tracking, signer: signer, events: events, proxies: proxies}
+}
+
+func (s *redirectHandlers) Register(r chi.Router) {
+	r.Get("/{token}", s.GetRedirect)
+}
+
+// GetRedirect records the click and redirects to the link. Links outlive the
+// tracking, an expired token still redirects but the click isn't recorded.
+func (s *redirectHandlers) GetRedirect(w http.ResponseWriter, r *http.Request) {
+	id, err := s.signer.Verify(token.Click, chi.URLParam(r, "token"))
+	expired := errors.Is(err, token.ErrExpired)
+	if err != nil && !expired {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	link, err := s.tracking.GetLink(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if expired {
+		http.Redirect(w, r, link.URL, http.StatusFound)
+		return
+	}
+
+	err = s.tracking.RecordClick(r.Context(), model.ClickEvent{
+		LinkID:    link.ID,
+		MailID:    link.MailID,
+		URL:       link.URL,
+		IP:        s.proxies.IP(r),
+		UserAgent: r.UserAgent(),
+	})
+	if err != nil {
+		log.Println(err)
+	} else {
+		s.events.Emit(webhook.Event{Type: model.EventMailClicked, MailID: link.MailID, URL: link.URL})
+	}
+
+	http.Redirect(w, r, link.URL, http.StatusFound)
+}
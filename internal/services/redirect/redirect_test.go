@@ -0,0 +1,93 @@
+package redirect
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"mail-service/internal/webhook"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeTracking struct {
+	storage.Tracking
+	links  map[uuid.UUID]model.Link
+	clicks []model.ClickEvent
+}
+
+func (f *fakeTracking) GetLink(_ context.Context, id uuid.UUID) (model.Link, error) {
+	link, ok := f.links[id]
+	if !ok {
+		return model.Link{}, sql.ErrNoRows
+	}
+	return link, nil
+}
+
+func (f *fakeTracking) RecordClick(_ context.Context, event model.ClickEvent) error {
+	f.clicks = append(f.clicks, event)
+	return nil
+}
+
+type fakeEmitter struct {
+	events []webhook.Event
+}
+
+func (f *fakeEmitter) Emit(event webhook.Event) {
+	f.events = append(f.events, event)
+}
+
+func TestGetRedirect(t *testing.T) {
+	keys := []token.Key{{ID: "k1", Secret: []byte("secret")}}
+	current, err := token.NewSigner(keys, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := token.NewSigner(keys, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := model.Link{ID: uuid.New(), MailID: uuid.New(), URL: "https://example.com/page"}
+
+	tests := []struct {
+		name       string
+		token      string
+		wantStatus int
+		wantClick  bool
+	}{
+		{name: "valid", token: current.Sign(token.Click, link.ID), wantStatus: http.StatusFound, wantClick: true},
+		{name: "expired", token: expired.Sign(token.Click, link.ID), wantStatus: http.StatusFound},
+		{name: "other purpose", token: current.Sign(token.Open, link.ID), wantStatus: http.StatusNotFound},
+		{name: "invalid", token: "k1.x.y", wantStatus: http.StatusNotFound},
+		{name: "unknown link", token: current.Sign(token.Click, uuid.New()), wantStatus: http.StatusNotFound},
+		{name: "expired unknown link", token: expired.Sign(token.Click, uuid.New()), wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracking := &fakeTracking{links: map[uuid.UUID]model.Link{link.ID: link}}
+			events := &fakeEmitter{}
+			r := chi.NewRouter()
+			NewRedirectHandlers(tracking, current, events, nil).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+tt.token, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusFound && w.Header().Get("Location") != link.URL {
+				t.Errorf("Location = %q, want %q", w.Header().Get("Location"), link.URL)
+			}
+			if clicked := len(tracking.clicks) == 1 && len(events.events) == 1; clicked != tt.wantClick {
+				t.Errorf("clicks = %v, events = %v, want recorded %v", tracking.clicks, events.events, tt.wantClick)
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package segments
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/segment"
+	"mail-service/internal/storage"
+	"mime"
+	"net/http"
+)
+
+// previewSample is the number of users returned with a preview.
+const previewSample = 10
+
+// maxBodyBytes leaves room for the name and the JSON escaping around an
+// expression of the max length.
+const maxBodyBytes = 4 * segment.MaxLength
+
+type SegmentHandlers interface {
+	Register(r chi.Router)
+	PostCreateSegment(w http.ResponseWriter, r *http.Request)
+	GetSegments(w http.ResponseWriter, r *http.Request)
+	GetSegment(w http.ResponseWriter, r *http.Request)
+	DeleteSegment(w http.ResponseWriter, r *http.Request)
+	PostPreview(w http.ResponseWriter, r *http.Request)
+	GetSegmentPreview(w http.ResponseWriter, r *http.Request)
+}
+
+type segmentHandlers struct {
+	storage storage.Segment
+}
+
+func NewSegmentHandlers(storage storage.Segment) SegmentHandlers {
+	return &segmentHandlers{storage: storage}
+}
+
+func (s *segmentHandlers) Register(r chi.Router) {
+	r.Post("/", s.PostCreateSegment)
+	r.Get("/", s.GetSegments)
+	r.Post("/preview", s.PostPreview)
+	r.Get("/{segment_id}", s.GetSegment)
+	r.Delete("/{segment_id}", s.DeleteSegment)
+	r.Get("/{segment_id}/preview", s.GetSegmentPreview)
+}
+
+func (s *segmentHandlers) PostCreateSegment(w http.ResponseWriter, r *http.Request) {
+	var seg model.Segment
+	if !decode(w, r, &seg) {
+		return
+	}
+
+	// The error tells where the expression is wrong.
+	err := seg.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	id, err := s.storage.CreateSegment(r.Context(), seg)
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, storage.ErrConflict) {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(id.String()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *segmentHandlers) GetSegments(w http.ResponseWriter, r *http.Request) {
+	segments, err := s.storage.GetSegments(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(segments)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *segmentHandlers) GetSegment(w http.ResponseWriter, r *http.Request) {
+	seg, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+
+	err := json.NewEncoder(w).Encode(seg)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *segmentHandlers) DeleteSegment(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "segment_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.DeleteSegment(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// PostPreview counts the users matching an expression without saving it, so
+// a segment can be tried out before it is created.
+func (s *segmentHandlers) PostPreview(w http.ResponseWriter, r *http.Request) {
+	var seg model.Segment
+	if !decode(w, r, &seg) {
+		return
+	}
+
+	s.preview(w, r, seg.Expression)
+}
+
+func (s *segmentHandlers) GetSegmentPreview(w http.ResponseWriter, r *http.Request) {
+	seg, ok := s.get(w, r)
+	if !ok {
+		return
+	}
+
+	s.preview(w, r, seg.Expression)
+}
+
+func (s *segmentHandlers) preview(w http.ResponseWriter, r *http.Request, expression string) {
+	expr, err := segment.Parse(expression)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = w.Write([]byte(err.Error()))
+		return
+	}
+
+	preview, err := s.storage.PreviewSegment(r.Context(), expr, previewSample)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(preview)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *segmentHandlers) get(w http.ResponseWriter, r *http.Request) (model.Segment, bool) {
+	id, err := uuid.Parse(chi.URLParam(r, "segment_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return model.Segment{}, false
+	}
+
+	seg, err := s.storage.GetSegment(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return model.Segment{}, false
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return model.Segment{}, false
+	}
+
+	return seg, true
+}
+
+func decode(w http.ResponseWriter, r *http.Request, seg *model.Segment) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return false
+	}
+
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(seg)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
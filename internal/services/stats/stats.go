@@ -0,0 +1,136 @@
+package stats
+
+import (
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"mail-service/internal/storage"
+	"net/http"
+	"time"
+)
+
+var buckets = map[string]bool{
+	"hour":  true,
+	"day":   true,
+	"week":  true,
+	"month": true,
+}
+
+const defaultPeriod = 30 * 24 * time.Hour
+
+type StatsHandlers interface {
+	Register(r chi.Router)
+	GetStatsByTime(w http.ResponseWriter, r *http.Request)
+	GetStatsByGroup(w http.ResponseWriter, r *http.Request)
+	GetStatsByTemplate(w http.ResponseWriter, r *http.Request)
+}
+
+type statsHandlers struct {
+	storage storage.Stats
+}
+
+func NewStatsHandlers(storage storage.Stats) StatsHandlers {
+	return &statsHandlers{storage: storage}
+}
+
+func (s *statsHandlers) Register(r chi.Router) {
+	r.Get("/", s.GetStatsByTime)
+	r.Get("/groups", s.GetStatsByGroup)
+	r.Get("/templates", s.GetStatsByTemplate)
+}
+
+func (s *statsHandlers) GetStatsByTime(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parsePeriod(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	bucket := r.URL.Query().Get("bucket")
+	if bucket == "" {
+		bucket = "day"
+	}
+	if !buckets[bucket] {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.storage.GetStatsByTime(r.Context(), bucket, from, to)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *statsHandlers) GetStatsByGroup(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parsePeriod(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.storage.GetStatsByGroup(r.Context(), from, to)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *statsHandlers) GetStatsByTemplate(w http.ResponseWriter, r *http.Request) {
+	from, to, ok := parsePeriod(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	stats, err := s.storage.GetStatsByTemplate(r.Context(), from, to)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(stats)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// parsePeriod reads the optional from and to query params, by default the
+// last 30 days are used.
+func parsePeriod(r *http.Request) (time.Time, time.Time, bool) {
+	to := time.Now()
+	if v := r.URL.Query().Get("to"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		to = t
+	}
+
+	from := to.Add(-defaultPeriod)
+	if v := r.URL.Query().Get("from"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+
+	return from, to, from.Before(to)
+}
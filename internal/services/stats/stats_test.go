@@ -0,0 +1,129 @@
+package stats
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"mail-service/internal/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type statsCall struct {
+	bucket   string
+	from, to time.Time
+}
+
+type fakeStats struct {
+	calls []statsCall
+	err   error
+}
+
+func (f *fakeStats) GetStatsByTime(_ context.Context, bucket string, from, to time.Time) ([]model.TimeStats, error) {
+	f.calls = append(f.calls, statsCall{bucket, from, to})
+	return []model.TimeStats{{Bucket: "2021-09-05T00:00:00Z", Stats: model.Stats{Sent: 3}}}, f.err
+}
+
+func (f *fakeStats) GetStatsByGroup(_ context.Context, from, to time.Time) ([]model.GroupStats, error) {
+	f.calls = append(f.calls, statsCall{"", from, to})
+	return []model.GroupStats{}, f.err
+}
+
+func (f *fakeStats) GetStatsByTemplate(_ context.Context, from, to time.Time) ([]model.TemplateStats, error) {
+	f.calls = append(f.calls, statsCall{"", from, to})
+	return []model.TemplateStats{{Template: "template", Stats: model.Stats{Opens: 2}}}, f.err
+}
+
+func TestGetStats(t *testing.T) {
+	from := time.Date(2021, 9, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 9, 5, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		path       string
+		err        error
+		wantStatus int
+		wantCall   *statsCall
+		wantBody   string
+	}{
+		{
+			name:       "by time",
+			path:       "/?from=2021-09-01T00:00:00Z&to=2021-09-05T00:00:00Z",
+			wantStatus: http.StatusOK,
+			wantCall:   &statsCall{"day", from, to},
+			wantBody:   `[{"bucket":"2021-09-05T00:00:00Z","sent":3,"failed":0,"opened":0,"opens":0,"clicked":0,"clicks":0}]`,
+		},
+		{
+			name:       "bucket",
+			path:       "/?bucket=week&from=2021-09-01T00:00:00Z&to=2021-09-05T00:00:00Z",
+			wantStatus: http.StatusOK,
+			wantCall:   &statsCall{"week", from, to},
+		},
+		{
+			name:       "default from",
+			path:       "/?to=2021-09-05T00:00:00Z",
+			wantStatus: http.StatusOK,
+			wantCall:   &statsCall{"day", to.Add(-defaultPeriod), to},
+		},
+		{
+			name:       "empty groups",
+			path:       "/groups?from=2021-09-01T00:00:00Z&to=2021-09-05T00:00:00Z",
+			wantStatus: http.StatusOK,
+			wantCall:   &statsCall{"", from, to},
+			wantBody:   `[]`,
+		},
+		{
+			name:       "templates",
+			path:       "/templates?from=2021-09-01T00:00:00Z&to=2021-09-05T00:00:00Z",
+			wantStatus: http.StatusOK,
+			wantCall:   &statsCall{"", from, to},
+			wantBody:   `[{"template":"template","sent":0,"failed":0,"opened":0,"opens":2,"clicked":0,"clicks":0}]`,
+		},
+		{name: "unknown bucket", path: "/?bucket=year", wantStatus: http.StatusBadRequest},
+		{name: "invalid from", path: "/groups?from=yesterday", wantStatus: http.StatusBadRequest},
+		{name: "from after to", path: "/templates?from=2021-09-05T00:00:00Z&to=2021-09-01T00:00:00Z", wantStatus: http.StatusBadRequest},
+		{
+			name:       "storage error",
+			path:       "/?from=2021-09-01T00:00:00Z&to=2021-09-05T00:00:00Z",
+			err:        errors.New("db is down"),
+			wantStatus: http.StatusInternalServerError,
+			wantCall:   &statsCall{"day", from, to},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats := &fakeStats{err: tt.err}
+			r := chi.NewRouter()
+			NewStatsHandlers(stats).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantCall == nil {
+				if len(stats.calls) != 0 {
+					t.Errorf("calls = %v, want none", stats.calls)
+				}
+				return
+			}
+			if len(stats.calls) != 1 {
+				t.Fatalf("calls = %v, want one", stats.calls)
+			}
+			call := stats.calls[0]
+			if call.bucket != tt.wantCall.bucket || !call.from.Equal(tt.wantCall.from) || !call.to.Equal(tt.wantCall.to) {
+				t.Errorf("call = %+v, want %+v", call, *tt.wantCall)
+			}
+			if tt.wantBody != "" {
+				if got := strings.TrimSpace(w.Body.String()); got != tt.wantBody {
+					t.Errorf("body = %s, want %s", got, tt.wantBody)
+				}
+			}
+		})
+	}
+}
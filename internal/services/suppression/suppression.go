@@ -0,0 +1,108 @@
+package suppression
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"log"
+	"mail-service/internal/address"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mime"
+	"net/http"
+)
+
+type SuppressionHandlers interface {
+	Register(r chi.Router)
+	PostAddSuppression(w http.ResponseWriter, r *http.Request)
+	GetSuppressions(w http.ResponseWriter, r *http.Request)
+	DeleteSuppression(w http.ResponseWriter, r *http.Request)
+}
+
+type suppressionHandlers struct {
+	storage storage.Suppression
+}
+
+func NewSuppressionHandlers(storage storage.Suppression) SuppressionHandlers {
+	return &suppressionHandlers{storage: storage}
+}
+
+func (s *suppressionHandlers) Register(r chi.Router) {
+	r.Post("/", s.PostAddSuppression)
+	r.Get("/", s.GetSuppressions)
+	r.Delete("/{address}", s.DeleteSuppression)
+}
+
+func (s *suppressionHandlers) PostAddSuppression(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var suppression model.Suppression
+	err = json.NewDecoder(r.Body).Decode(&suppression)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if suppression.Reason == "" {
+		suppression.Reason = model.SuppressionManual
+	}
+	suppression.Source = "api"
+	suppression.Address = address.Normalize(suppression.Address)
+
+	err = suppression.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.AddSuppression(r.Context(), suppression)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (s *suppressionHandlers) GetSuppressions(w http.ResponseWriter, r *http.Request) {
+	suppressions, err := s.storage.GetSuppressions(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(suppressions)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *suppressionHandlers) DeleteSuppression(w http.ResponseWriter, r *http.Request) {
+	address := chi.URLParam(r, "address")
+
+	err := s.storage.RemoveSuppression(r.Context(), address)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,105 @@
+package suppression
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type fakeSuppressions struct {
+	storage.Suppression
+	added     []model.Suppression
+	addresses map[string]bool
+}
+
+func (f *fakeSuppressions) AddSuppression(_ context.Context, suppression model.Suppression) error {
+	f.added = append(f.added, suppression)
+	return nil
+}
+
+func (f *fakeSuppressions) RemoveSuppression(_ context.Context, address string) error {
+	if !f.addresses[address] {
+		return sql.ErrNoRows
+	}
+	delete(f.addresses, address)
+	return nil
+}
+
+func TestPostAddSuppression(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		want        []model.Suppression
+	}{
+		{
+			name:        "default reason",
+			contentType: "application/json",
+			body:        `{"address":"user@example.com"}`,
+			wantStatus:  http.StatusCreated,
+			want:        []model.Suppression{{Address: "user@example.com", Reason: model.SuppressionManual, Source: "api"}},
+		},
+		{
+			name:        "source can't be set",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"address":"user@example.com","reason":"complaint","source":"arf"}`,
+			wantStatus:  http.StatusCreated,
+			want:        []model.Suppression{{Address: "user@example.com", Reason: model.SuppressionComplaint, Source: "api"}},
+		},
+		{name: "unknown reason", contentType: "application/json", body: `{"address":"user@example.com","reason":"other"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid address", contentType: "application/json", body: `{"address":"user"}`, wantStatus: http.StatusBadRequest},
+		{name: "invalid json", contentType: "application/json", body: `{`, wantStatus: http.StatusBadRequest},
+		{name: "form", contentType: "application/x-www-form-urlencoded", body: "address=user@example.com", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suppressions := &fakeSuppressions{}
+			r := chi.NewRouter()
+			NewSuppressionHandlers(suppressions).Register(r)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !reflect.DeepEqual(suppressions.added, tt.want) {
+				t.Errorf("added = %+v, want %+v", suppressions.added, tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteSuppression(t *testing.T) {
+	tests := []struct {
+		address    string
+		wantStatus int
+	}{
+		{address: "user@example.com", wantStatus: http.StatusOK},
+		{address: "other@example.com", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		suppressions := &fakeSuppressions{addresses: map[string]bool{"user@example.com": true}}
+		r := chi.NewRouter()
+		NewSuppressionHandlers(suppressions).Register(r)
+
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/"+tt.address, nil))
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("DELETE %s status = %d, want %d", tt.address, w.Code, tt.wantStatus)
+		}
+	}
+}
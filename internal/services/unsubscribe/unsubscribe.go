@@ -0,0 +1,162 @@
+package unsubscribe
+
+import (
+	"context"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"html/template"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"net/http"
+)
+
+const (
+	scopeGroup = "group"
+	scopeAll   = "all"
+)
+
+// Sources of the preference changes made here.
+const (
+	sourcePage     = "unsubscribe_page"
+	sourceOneClick = "one_click"
+)
+
+type UnsubscribeHandlers interface {
+	Register(r chi.Router)
+	GetUnsubscribePage(w http.ResponseWriter, r *http.Request)
+	PostUnsubscribe(w http.ResponseWriter, r *http.Request)
+}
+
+type unsubscribeHandlers struct {
+	mails        storage.Mail
+	users        storage.User
+	groups       storage.Group
+	preferences  storage.Preferences
+	suppressions storage.Suppression
+	signer       *token.Signer
+}
+
+func NewUnsubscribeHandlers(mails storage.Mail, users storage.User, groups storage.Group, preferences storage.Preferences, suppressions storage.Suppression, signer *token.Signer) UnsubscribeHandlers {
+	return &unsubscribeHandlers{mails: mails, users: users, groups: groups, preferences: preferences, suppressions: suppressions, signer: signer}
+}
+
+func (s *unsubscribeHandlers) Register(r chi.Router) {
+	r.Get("/{token}", s.GetUnsubscribePage)
+	r.Post("/{token}", s.PostUnsubscribe)
+}
+
+type pageData struct {
+	Email string
+	Group string
+	Done  bool
+}
+
+func (s *unsubscribeHandlers) GetUnsubscribePage(w http.ResponseWriter, r *http.Request) {
+	mail, user, err := s.resolve(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	data := pageData{Email: user.Email}
+	if mail.GroupId.Valid {
+		group, err := s.groups.GetGroupById(r.Context(), mail.GroupId.UUID)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		data.Group = group.Name
+	}
+
+	renderPage(w, data)
+}
+
+// PostUnsubscribe handles both the RFC 8058 one-click request made by mail
+// clients and the confirmation page form. One-click requests unsubscribe
+// from the group the mail was sent to. Group unsubscribes are recorded in
+// the preference changes like the ones made in the preference center.
+func (s *unsubscribeHandlers) PostUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	mail, user, err := s.resolve(r.Context(), chi.URLParam(r, "token"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	oneClick := r.PostForm.Get("List-Unsubscribe") == "One-Click"
+	scope := r.PostForm.Get("scope")
+	if oneClick {
+		scope = scopeGroup
+	}
+	if scope != scopeGroup && scope != scopeAll {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if scope == scopeGroup && mail.GroupId.Valid {
+		source := sourcePage
+		if oneClick {
+			source = sourceOneClick
+		}
+		err = s.preferences.SetGroupSubscription(r.Context(), user.ID, mail.GroupId.UUID, false, source)
+	} else {
+		err = s.suppressions.AddSuppression(r.Context(), model.Suppression{
+			Address: user.Email,
+			Reason:  model.SuppressionUnsubscribe,
+			Source:  "unsubscribe",
+		})
+	}
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	if oneClick {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	renderPage(w, pageData{Done: true})
+}
+
+func (s *unsubscribeHandlers) resolve(ctx context.Context, t string) (model.Mail, model.User, error) {
+	id, err := s.signer.Verify(token.Unsubscribe, t)
+	if err != nil {
+		return model.Mail{}, model.User{}, err
+	}
+
+	mail, err := s.mails.GetMailById(ctx, id)
+	if err != nil {
+		return model.Mail{}, model.User{}, fmt.Errorf("can't get mail: %w", err)
+	}
+
+	user, err := s.users.GetUser(ctx, mail.ToUserId)
+	if err != nil {
+		return model.Mail{}, model.User{}, fmt.Errorf("can't get user: %w", err)
+	}
+
+	return mail, user, nil
+}
+
+func renderPage(w http.ResponseWriter, data pageData) {
+	tmpl, err := template.ParseFiles("templates/pages/unsubscribe.html")
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = tmpl.Execute(w, data)
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,128 @@
+package unsubscribe
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mail-service/internal/token"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeMails struct {
+	storage.Mail
+	mail model.Mail
+}
+
+func (f *fakeMails) GetMailById(_ context.Context, _ uuid.UUID) (model.Mail, error) {
+	return f.mail, nil
+}
+
+type fakeUsers struct {
+	storage.User
+	user model.User
+}
+
+func (f *fakeUsers) GetUser(_ context.Context, _ uuid.UUID) (model.User, error) {
+	return f.user, nil
+}
+
+type subscriptionChange struct {
+	UserID, GroupID uuid.UUID
+	Subscribed      bool
+	Source          string
+}
+
+type fakePreferences struct {
+	storage.Preferences
+	changes []subscriptionChange
+}
+
+func (f *fakePreferences) SetGroupSubscription(_ context.Context, userID, groupID uuid.UUID, subscribed bool, source string) error {
+	f.changes = append(f.changes, subscriptionChange{userID, groupID, subscribed, source})
+	return nil
+}
+
+type fakeSuppressions struct {
+	storage.Suppression
+	suppressions []model.Suppression
+}
+
+func (f *fakeSuppressions) AddSuppression(_ context.Context, suppression model.Suppression) error {
+	f.suppressions = append(f.suppressions, suppression)
+	return nil
+}
+
+func TestPostUnsubscribe(t *testing.T) {
+	signer, err := token.NewSigner([]token.Key{{ID: "k1", Secret: []byte("secret")}}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := model.User{ID: uuid.New(), Email: "user@example.com"}
+	groupID := uuid.New()
+	groupMail := model.Mail{ID: uuid.New(), ToUserId: user.ID, GroupId: uuid.NullUUID{UUID: groupID, Valid: true}}
+	segmentMail := model.Mail{ID: uuid.New(), ToUserId: user.ID, SegmentId: uuid.NullUUID{UUID: uuid.New(), Valid: true}}
+
+	tests := []struct {
+		name             string
+		mail             model.Mail
+		body             string
+		wantChanges      []subscriptionChange
+		wantSuppressions int
+	}{
+		{
+			name:        "one-click from a group mail",
+			mail:        groupMail,
+			body:        "List-Unsubscribe=One-Click",
+			wantChanges: []subscriptionChange{{user.ID, groupID, false, sourceOneClick}},
+		},
+		{
+			name:        "page from a group mail",
+			mail:        groupMail,
+			body:        "scope=group",
+			wantChanges: []subscriptionChange{{user.ID, groupID, false, sourcePage}},
+		},
+		{
+			name:             "page unsubscribe from all mails",
+			mail:             groupMail,
+			body:             "scope=all",
+			wantSuppressions: 1,
+		},
+		{
+			name:             "one-click from a segment mail",
+			mail:             segmentMail,
+			body:             "List-Unsubscribe=One-Click",
+			wantSuppressions: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			preferences := &fakePreferences{}
+			suppressions := &fakeSuppressions{}
+			r := chi.NewRouter()
+			NewUnsubscribeHandlers(
+				&fakeMails{mail: tt.mail}, &fakeUsers{user: user}, nil, preferences, suppressions, signer,
+			).Register(r)
+
+			req := httptest.NewRequest(http.MethodPost, "/"+signer.Sign(token.Unsubscribe, tt.mail.ID), strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if !reflect.DeepEqual(preferences.changes, tt.wantChanges) {
+				t.Errorf("changes = %+v, want %+v", preferences.changes, tt.wantChanges)
+			}
+			if len(suppressions.suppressions) != tt.wantSuppressions {
+				t.Errorf("suppressions = %+v, want %d", suppressions.suppressions, tt.wantSuppressions)
+			}
+		})
+	}
+}
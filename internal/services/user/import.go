@@ -0,0 +1,306 @@
+package user
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"log"
+	"mail-service/internal/model"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxLineSize = 1024 * 1024
+
+// optionalFields are the user fields an import row may omit to keep the
+// stored values.
+var optionalFields = []string{"first_name", "last_name", "timezone"}
+
+// errBadUpload is returned by the row readers when the upload can't be read
+// any further.
+var errBadUpload = errors.New("bad upload")
+
+type rowReader interface {
+	// next returns io.EOF after the last row, rows which can't be parsed are
+	// returned with Error set.
+	next() (model.ImportRow, error)
+}
+
+// PostImportUsers upserts users by email from a CSV or NDJSON upload and
+// responds with a NDJSON report line per row.
+func (s *userHandlers) PostImportUsers(w http.ResponseWriter, r *http.Request) {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if t != "text/csv" && t != "application/x-ndjson" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var groupID uuid.NullUUID
+	if v := r.URL.Query().Get("group_id"); v != "" {
+		groupID.UUID, err = uuid.Parse(v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		groupID.Valid = true
+
+		group, err := s.groups.GetGroupById(r.Context(), groupID.UUID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Println(err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		// Members of double opt-in groups have to confirm one by one.
+		if group.DoubleOptIn {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	definitions, err := s.attributes.GetAttributeDefinitions(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var rows rowReader
+	if t == "text/csv" {
+		rows, err = newCsvRows(r.Body, definitions)
+		if err != nil {
+			log.Println(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	} else {
+		rows = newNdjsonRows(r.Body)
+	}
+
+	next := func() (model.ImportRow, error) {
+		row, err := rows.next()
+		if err != nil || row.Error != "" {
+			return row, err
+		}
+
+		if err := s.checkRow(&row, definitions); err != nil {
+			row.Error = err.Error()
+		}
+		return row, nil
+	}
+
+	started := false
+	enc := json.NewEncoder(w)
+	report := func(result model.ImportResult) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			started = true
+		}
+		return enc.Encode(result)
+	}
+
+	err = s.storage.ImportUsers(r.Context(), groupID, next, report)
+	if err != nil {
+		log.Println(err)
+		if started {
+			return
+		}
+		if errors.Is(err, errBadUpload) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// checkRow checks the user of the row like check, except that the attribute
+// defaults and required attributes are left to the storage, they only apply
+// when the row creates a user.
+func (s *userHandlers) checkRow(row *model.ImportRow, definitions []model.AttributeDefinition) error {
+	err := s.checkFields(&row.User)
+	if err != nil {
+		return err
+	}
+
+	row.User.Attributes, err = row.User.Attributes.Check(definitions)
+	if err != nil {
+		return err
+	}
+
+	row.Defaults, err = row.User.Attributes.Defaults(definitions)
+	if err != nil {
+		row.CreateError = err.Error()
+	}
+	return nil
+}
+
+// csvRows reads users from a CSV with a header, the email, first_name,
+// last_name and timezone columns are user fields and any other column is an
+// attribute. Fields without a column are omitted from every row.
+type csvRows struct {
+	reader      *csv.Reader
+	header      []string
+	omitted     map[string]bool
+	definitions map[string]model.AttributeDefinition
+	row         int
+}
+
+func newCsvRows(body io.Reader, definitions []model.AttributeDefinition) (*csvRows, error) {
+	reader := csv.NewReader(body)
+	reader.FieldsPerRecord = -1
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("can't read header: %w", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	columns := make(map[string]bool, len(header))
+	for _, column := range header {
+		columns[column] = true
+	}
+	if !columns["email"] {
+		return nil, errors.New("email column is missing")
+	}
+
+	omitted := map[string]bool{}
+	for _, field := range optionalFields {
+		if !columns[field] {
+			omitted[field] = true
+		}
+	}
+
+	byName := make(map[string]model.AttributeDefinition, len(definitions))
+	for _, definition := range definitions {
+		byName[definition.Name] = definition
+	}
+
+	return &csvRows{reader: reader, header: header, omitted: omitted, definitions: byName}, nil
+}
+
+func (c *csvRows) next() (model.ImportRow, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return model.ImportRow{}, io.EOF
+	}
+
+	c.row++
+	row := model.ImportRow{Row: c.row, Omitted: c.omitted}
+
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		row.Error = parseErr.Err.Error()
+		return row, nil
+	}
+	if err != nil {
+		return row, fmt.Errorf("%w: %v", errBadUpload, err)
+	}
+	if len(record) != len(c.header) {
+		row.Error = fmt.Sprintf("expected %d fields, got %d", len(c.header), len(record))
+		return row, nil
+	}
+
+	for i, column := range c.header {
+		value := record[i]
+		switch column {
+		case "email":
+			row.User.Email = value
+		case "first_name":
+			row.User.FirstName = value
+		case "last_name":
+			row.User.LastName = value
+		case "timezone":
+			row.User.Timezone = value
+		default:
+			if value == "" {
+				continue
+			}
+			if row.User.Attributes == nil {
+				row.User.Attributes = model.Attributes{}
+			}
+			row.User.Attributes[column] = c.attribute(column, value)
+		}
+	}
+
+	return row, nil
+}
+
+// attribute converts the cell to the declared type of the attribute, values
+// which can't be converted are left as strings to fail the type check.
+func (c *csvRows) attribute(name, value string) interface{} {
+	switch c.definitions[name].Type {
+	case model.AttributeNumber:
+		if n, err := strconv.ParseFloat(value, 64); err == nil {
+			return n
+		}
+	case model.AttributeBoolean:
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// ndjsonRows reads users from JSON objects, one per line.
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	row     int
+}
+
+func newNdjsonRows(body io.Reader) *ndjsonRows {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	return &ndjsonRows{scanner: scanner}
+}
+
+func (n *ndjsonRows) next() (model.ImportRow, error) {
+	for n.scanner.Scan() {
+		n.row++
+		line := strings.TrimSpace(n.scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		row := model.ImportRow{Row: n.row}
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(line), &fields); err != nil {
+			row.Error = err.Error()
+			return row, nil
+		}
+		if err := json.Unmarshal([]byte(line), &row.User); err != nil {
+			row.Error = err.Error()
+		}
+		row.User.ID = uuid.Nil
+
+		for _, field := range optionalFields {
+			if _, ok := fields[field]; !ok {
+				if row.Omitted == nil {
+					row.Omitted = map[string]bool{}
+				}
+				row.Omitted[field] = true
+			}
+		}
+		return row, nil
+	}
+
+	if err := n.scanner.Err(); err != nil {
+		return model.ImportRow{}, fmt.Errorf("%w: %v", errBadUpload, err)
+	}
+	return model.ImportRow{}, io.EOF
+}
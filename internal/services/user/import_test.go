@@ -0,0 +1,211 @@
+package user
+
+import (
+	"errors"
+	"io"
+	"mail-service/internal/model"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readRows(t *testing.T, rows rowReader) []model.ImportRow {
+	t.Helper()
+
+	var got []model.ImportRow
+	for {
+		row, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			return got
+		}
+		if err != nil {
+			t.Fatalf("next error: %v", err)
+		}
+		got = append(got, row)
+	}
+}
+
+func TestCsvRows(t *testing.T) {
+	definitions := []model.AttributeDefinition{
+		{Name: "score", Type: model.AttributeNumber},
+		{Name: "vip", Type: model.AttributeBoolean},
+	}
+
+	tests := []struct {
+		name string
+		in   string
+		want []model.ImportRow
+	}{
+		{
+			name: "all fields",
+			in:   "email,first_name,last_name,timezone\na@b.example,Ann,Lee,Europe/Berlin\n",
+			want: []model.ImportRow{{
+				Row:     1,
+				User:    model.User{Email: "a@b.example", FirstName: "Ann", LastName: "Lee", Timezone: "Europe/Berlin"},
+				Omitted: map[string]bool{},
+			}},
+		},
+		{
+			name: "missing columns are omitted",
+			in:   "email, plan\na@b.example,pro\n",
+			want: []model.ImportRow{{
+				Row:     1,
+				User:    model.User{Email: "a@b.example", Attributes: model.Attributes{"plan": "pro"}},
+				Omitted: map[string]bool{"first_name": true, "last_name": true, "timezone": true},
+			}},
+		},
+		{
+			name: "typed attributes",
+			in:   "email,score,vip,note\na@b.example,10,true,\nc@d.example,many,no,x\n",
+			want: []model.ImportRow{
+				{
+					Row:     1,
+					User:    model.User{Email: "a@b.example", Attributes: model.Attributes{"score": 10.0, "vip": true}},
+					Omitted: map[string]bool{"first_name": true, "last_name": true, "timezone": true},
+				},
+				{
+					Row:     2,
+					User:    model.User{Email: "c@d.example", Attributes: model.Attributes{"score": "many", "vip": "no", "note": "x"}},
+					Omitted: map[string]bool{"first_name": true, "last_name": true, "timezone": true},
+				},
+			},
+		},
+		{
+			name: "wrong number of fields",
+			in:   "email,first_name\na@b.example\n",
+			want: []model.ImportRow{{
+				Row:     1,
+				Omitted: map[string]bool{"last_name": true, "timezone": true},
+				Error:   "expected 2 fields, got 1",
+			}},
+		},
+		{
+			name: "bad quote",
+			in:   "email\n\"a@b.example\n",
+			want: []model.ImportRow{{
+				Row:     1,
+				Omitted: map[string]bool{"first_name": true, "last_name": true, "timezone": true},
+				Error:   "extraneous or missing \" in quoted-field",
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := newCsvRows(strings.NewReader(tt.in), definitions)
+			if err != nil {
+				t.Fatalf("newCsvRows error: %v", err)
+			}
+			if got := readRows(t, rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCsvRowsWithoutEmail(t *testing.T) {
+	if _, err := newCsvRows(strings.NewReader("first_name\nAnn\n"), nil); err == nil {
+		t.Error("newCsvRows without an email column succeeded")
+	}
+}
+
+func TestNdjsonRows(t *testing.T) {
+	in := `{"email": "a@b.example", "first_name": "Ann", "last_name": "Lee", "timezone": "UTC", "attributes": {"plan": "pro"}}
+
+{"id": "5a3c6e8e-7c53-4b6a-9a5c-0b1b1b1b1b1b", "email": "c@d.example", "last_name": ""}
+{"email": 1}
+not json
+`
+	want := []model.ImportRow{
+		{
+			Row: 1,
+			User: model.User{
+				Email: "a@b.example", FirstName: "Ann", LastName: "Lee", Timezone: "UTC",
+				Attributes: model.Attributes{"plan": "pro"},
+			},
+		},
+		{
+			Row:     3,
+			User:    model.User{Email: "c@d.example"},
+			Omitted: map[string]bool{"first_name": true, "timezone": true},
+		},
+		{
+			Row:     4,
+			Omitted: map[string]bool{"first_name": true, "last_name": true, "timezone": true},
+			Error:   "json: cannot unmarshal number into Go struct field User.email of type string",
+		},
+		{
+			Row:   5,
+			Error: "invalid character 'o' in literal null (expecting 'u')",
+		},
+	}
+
+	if got := readRows(t, newNdjsonRows(strings.NewReader(in))); !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %+v, want %+v", got, want)
+	}
+}
+
+func TestCheckRow(t *testing.T) {
+	definitions := []model.AttributeDefinition{
+		{Name: "plan", Type: model.AttributeString, Default: model.NullValue{Data: "free", Valid: true}},
+		{Name: "region", Type: model.AttributeString, Required: true},
+		{Name: "score", Type: model.AttributeNumber},
+	}
+
+	tests := []struct {
+		name            string
+		attributes      model.Attributes
+		wantAttributes  model.Attributes
+		wantDefaults    model.Attributes
+		wantCreateError string
+		wantErr         bool
+	}{
+		{
+			name:           "complete",
+			attributes:     model.Attributes{"plan": "pro", "region": "eu", "score": 3.0},
+			wantAttributes: model.Attributes{"plan": "pro", "region": "eu", "score": 3.0},
+			wantDefaults:   model.Attributes{},
+		},
+		{
+			name:            "partial",
+			attributes:      model.Attributes{"score": 3.0, "nickname": "ann", "region": nil},
+			wantAttributes:  model.Attributes{"score": 3.0, "nickname": "ann"},
+			wantCreateError: `attribute "region" is required`,
+		},
+		{
+			name:           "defaults",
+			attributes:     model.Attributes{"region": "eu"},
+			wantAttributes: model.Attributes{"region": "eu"},
+			wantDefaults:   model.Attributes{"plan": "free"},
+		},
+		{
+			name:       "wrong type",
+			attributes: model.Attributes{"score": "high"},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &userHandlers{}
+			row := model.ImportRow{Row: 1, User: model.User{Email: "a@b.example", Attributes: tt.attributes}}
+
+			err := s.checkRow(&row, definitions)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkRow error = %v, want error %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(row.User.Attributes, tt.wantAttributes) {
+				t.Errorf("attributes = %v, want %v", row.User.Attributes, tt.wantAttributes)
+			}
+			if !reflect.DeepEqual(row.Defaults, tt.wantDefaults) {
+				t.Errorf("defaults = %v, want %v", row.Defaults, tt.wantDefaults)
+			}
+			if row.CreateError != tt.wantCreateError {
+				t.Errorf("create error = %q, want %q", row.CreateError, tt.wantCreateError)
+			}
+		})
+	}
+}
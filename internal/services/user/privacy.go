@@ -0,0 +1,80 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"mail-service/internal/queue"
+	"net/http"
+)
+
+// GetUserExport responds with everything stored about the user as a JSON
+// archive.
+func (s *userHandlers) GetUserExport(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	archive, err := s.storage.ExportUser(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="user-%s.json"`, id))
+	err = json.NewEncoder(w).Encode(archive)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// PostEraseUser deletes the user with everything related to them, cancels
+// their scheduled mails and keeps only the hash of their address on the
+// suppression list so they are never mailed again.
+func (s *userHandlers) PostEraseUser(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	pending, err := s.storage.EraseUser(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// The mails are already deleted, the worker skips the ones left in the
+	// queue, so a failure here isn't reported to the caller.
+	s.cancel(r.Context(), pending)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *userHandlers) cancel(ctx context.Context, ids []uuid.UUID) {
+	mails := make([]queue.Mail, 0, len(ids))
+	for _, id := range ids {
+		mails = append(mails, queue.Mail{ID: id})
+	}
+
+	if err := s.queue.Cancel(ctx, mails...); err != nil {
+		log.Printf("can't cancel scheduled mails: %v", err)
+	}
+}
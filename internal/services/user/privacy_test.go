@@ -0,0 +1,83 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/queue"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+type fakeErasure struct {
+	storage.User
+	pending map[uuid.UUID][]uuid.UUID
+	erased  []uuid.UUID
+}
+
+func (f *fakeErasure) EraseUser(_ context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	pending, ok := f.pending[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	f.erased = append(f.erased, id)
+	return pending, nil
+}
+
+type fakeQueue struct {
+	queue.DelayedQueue
+	cancelled []queue.Mail
+}
+
+func (f *fakeQueue) Cancel(_ context.Context, mails ...queue.Mail) error {
+	f.cancelled = append(f.cancelled, mails...)
+	return nil
+}
+
+func TestPostEraseUser(t *testing.T) {
+	withMails, withoutMails := uuid.New(), uuid.New()
+	first, second := uuid.New(), uuid.New()
+
+	tests := []struct {
+		name          string
+		id            string
+		wantStatus    int
+		wantCancelled []queue.Mail
+	}{
+		{name: "pending mails", id: withMails.String(), wantStatus: http.StatusOK, wantCancelled: []queue.Mail{{ID: first}, {ID: second}}},
+		{name: "no pending mails", id: withoutMails.String(), wantStatus: http.StatusOK},
+		{name: "unknown user", id: uuid.NewString(), wantStatus: http.StatusNotFound},
+		{name: "invalid id", id: "123", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeErasure{pending: map[uuid.UUID][]uuid.UUID{
+				withMails:    {first, second},
+				withoutMails: {},
+			}}
+			q := &fakeQueue{}
+			r := chi.NewRouter()
+			NewUserHandlers(users, nil, nil, q, nil).Register(r)
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/"+tt.id+"/erase", nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if len(q.cancelled) != 0 || len(tt.wantCancelled) != 0 {
+				if !reflect.DeepEqual(q.cancelled, tt.wantCancelled) {
+					t.Errorf("cancelled = %v, want %v", q.cancelled, tt.wantCancelled)
+				}
+			}
+			if erased := len(users.erased) == 1; erased != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("erased = %v", users.erased)
+			}
+		})
+	}
+}
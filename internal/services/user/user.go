@@ -7,29 +7,66 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/google/uuid"
 	"log"
+	"mail-service/internal/address"
 	"mail-service/internal/model"
+	"mail-service/internal/queue"
 	"mail-service/internal/storage"
 	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+const defaultTimezone = "UTC"
+
+var errDisposable = errors.New("disposable email addresses are not allowed")
+
+// attrPrefix marks the listing query params which filter by an attribute,
+// e.g. attr.plan=pro.
+const attrPrefix = "attr."
+
 type UserHandlers interface {
 	Register(r chi.Router)
 	PostCreateUser(w http.ResponseWriter, r *http.Request)
 	GetUser(w http.ResponseWriter, r *http.Request)
+	GetUsers(w http.ResponseWriter, r *http.Request)
+	PutUser(w http.ResponseWriter, r *http.Request)
+	PatchUser(w http.ResponseWriter, r *http.Request)
+	DeleteUser(w http.ResponseWriter, r *http.Request)
+	PostImportUsers(w http.ResponseWriter, r *http.Request)
+	GetUserExport(w http.ResponseWriter, r *http.Request)
+	PostEraseUser(w http.ResponseWriter, r *http.Request)
 }
 
 type userHandlers struct {
-	storage storage.User
+	storage    storage.User
+	attributes storage.Attribute
+	groups     storage.Group
+	queue      queue.DelayedQueue
+	blocklist  address.Blocklist
 }
 
-func NewUserHandlers(storage storage.User) UserHandlers {
-	return &userHandlers{storage: storage}
+// NewUserHandlers creates the handlers, users with an address on the
+// blocklist are rejected.
+func NewUserHandlers(storage storage.User, attributes storage.Attribute, groups storage.Group, q queue.DelayedQueue, blocklist address.Blocklist) UserHandlers {
+	return &userHandlers{storage: storage, attributes: attributes, groups: groups, queue: q, blocklist: blocklist}
 }
 
 func (s *userHandlers) Register(r chi.Router) {
 	r.Post("/", s.PostCreateUser)
 	r.Get("/", s.GetUser)
+	r.Post("/import", s.PostImportUsers)
+	r.Put("/{user_id}", s.PutUser)
+	r.Patch("/{user_id}", s.PatchUser)
+	r.Delete("/{user_id}", s.DeleteUser)
+	r.Get("/{user_id}/export", s.GetUserExport)
+	r.Post("/{user_id}/erase", s.PostEraseUser)
 }
 
 func (s *userHandlers) PostCreateUser(w http.ResponseWriter, r *http.Request) {
@@ -54,11 +91,18 @@ func (s *userHandlers) PostCreateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !s.prepare(w, r, &user) {
+		return
+	}
+
 	id, err := s.storage.CreateUser(r.Context(), user)
-	if err != nil {
+	if err != nil && !errors.Is(err, storage.ErrConflict) {
 		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
+	} else if errors.Is(err, storage.ErrConflict) {
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
 	w.WriteHeader(http.StatusCreated)
 	_, err = w.Write([]byte(id.String()))
@@ -72,7 +116,7 @@ func (s *userHandlers) GetUser(w http.ResponseWriter, r *http.Request) {
 	userId := r.URL.Query().Get("userId")
 	email := r.URL.Query().Get("email")
 	if userId == "" && email == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		s.GetUsers(w, r)
 		return
 	}
 
@@ -80,22 +124,127 @@ func (s *userHandlers) GetUser(w http.ResponseWriter, r *http.Request) {
 	var err error
 
 	if userId != "" {
-		id, err := uuid.Parse(userId)
+		var id uuid.UUID
+		id, err = uuid.Parse(userId)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
 		user, err = s.storage.GetUser(r.Context(), id)
 	} else if email != "" {
-		user, err = s.storage.GetUserByEmail(r.Context(), email)
+		user, err = s.storage.GetUserByEmail(r.Context(), address.Normalize(email))
+	}
+
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(user)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+// GetUsers lists users page by page, the filters are taken from the query
+// params.
+func (s *userHandlers) GetUsers(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseFilter(r)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	users, err := s.storage.GetUsers(r.Context(), filter)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(users)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+}
+
+func (s *userHandlers) PutUser(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	if !isJson(w, r) {
+		return
+	}
+
+	var user model.User
+	err = json.NewDecoder(r.Body).Decode(&user)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	user.ID = id
+
+	s.updateUser(w, r, user)
+}
+
+func (s *userHandlers) PatchUser(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
 
+	if !isJson(w, r) {
+		return
+	}
+
+	var patch model.UserPatch
+	err = json.NewDecoder(r.Body).Decode(&patch)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.storage.GetUser(r.Context(), id)
 	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	patch.Apply(&user)
+
+	s.updateUser(w, r, user)
+}
+
+func (s *userHandlers) updateUser(w http.ResponseWriter, r *http.Request, user model.User) {
+	if !s.prepare(w, r, &user) {
+		return
+	}
+
+	user, err := s.storage.UpdateUser(r.Context(), user)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) && !errors.Is(err, storage.ErrConflict) {
+		log.Println(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	} else if errors.Is(err, sql.ErrNoRows) {
 		w.WriteHeader(http.StatusNotFound)
 		return
+	} else if errors.Is(err, storage.ErrConflict) {
+		w.WriteHeader(http.StatusConflict)
+		return
 	}
 
 	err = json.NewEncoder(w).Encode(user)
@@ -104,3 +253,132 @@ func (s *userHandlers) GetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 }
+
+// DeleteUser removes the user together with the user's mails and group
+// memberships.
+func (s *userHandlers) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.DeleteUser(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// prepare checks the user before it is stored, it writes the error status
+// itself.
+func (s *userHandlers) prepare(w http.ResponseWriter, r *http.Request, user *model.User) bool {
+	definitions, err := s.attributes.GetAttributeDefinitions(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return false
+	}
+
+	if err = s.check(user, definitions); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// check normalizes the email and checks the user against the validation
+// rules, the disposable domain blocklist and the attribute definitions.
+func (s *userHandlers) check(user *model.User, definitions []model.AttributeDefinition) error {
+	err := s.checkFields(user)
+	if err != nil {
+		return err
+	}
+
+	user.Attributes, err = user.Attributes.Conform(definitions)
+	return err
+}
+
+// checkFields normalizes the email and checks the user against the
+// validation rules and the disposable domain blocklist.
+func (s *userHandlers) checkFields(user *model.User) error {
+	user.Email = address.Normalize(user.Email)
+	if user.Timezone == "" {
+		user.Timezone = defaultTimezone
+	}
+
+	err := user.Validate()
+	if err != nil {
+		return err
+	}
+	if s.blocklist.Blocked(user.Email) {
+		return errDisposable
+	}
+	return nil
+}
+
+func isJson(w http.ResponseWriter, r *http.Request) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return false
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return false
+	}
+	return true
+}
+
+func parseFilter(r *http.Request) (model.UserFilter, bool) {
+	q := r.URL.Query()
+	filter := model.UserFilter{
+		EmailPrefix: q.Get("email_prefix"),
+		Name:        q.Get("name"),
+		Limit:       defaultLimit,
+	}
+
+	var err error
+	if v := q.Get("created_after"); v != "" {
+		if filter.CreatedAfter, err = time.Parse(time.RFC3339, v); err != nil {
+			return model.UserFilter{}, false
+		}
+	}
+	if v := q.Get("created_before"); v != "" {
+		if filter.CreatedBefore, err = time.Parse(time.RFC3339, v); err != nil {
+			return model.UserFilter{}, false
+		}
+	}
+	if v := q.Get("group_id"); v != "" {
+		if filter.GroupID.UUID, err = uuid.Parse(v); err != nil {
+			return model.UserFilter{}, false
+		}
+		filter.GroupID.Valid = true
+	}
+	for key, values := range q {
+		if name := strings.TrimPrefix(key, attrPrefix); name != key && name != "" {
+			if filter.Attributes == nil {
+				filter.Attributes = map[string]string{}
+			}
+			filter.Attributes[name] = values[0]
+		}
+	}
+	if v := q.Get("limit"); v != "" {
+		if filter.Limit, err = strconv.Atoi(v); err != nil || filter.Limit < 1 || filter.Limit > maxLimit {
+			return model.UserFilter{}, false
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		if filter.Offset, err = strconv.Atoi(v); err != nil || filter.Offset < 0 {
+			return model.UserFilter{}, false
+		}
+	}
+
+	return filter, true
+}
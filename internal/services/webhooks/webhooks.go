@@ -0,0 +1,172 @@
+package webhooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"mime"
+	"net/http"
+)
+
+type WebhookHandlers interface {
+	Register(r chi.Router)
+	PostCreateWebhook(w http.ResponseWriter, r *http.Request)
+	GetWebhooks(w http.ResponseWriter, r *http.Request)
+	GetWebhook(w http.ResponseWriter, r *http.Request)
+	DeleteWebhook(w http.ResponseWriter, r *http.Request)
+	GetWebhookDeliveries(w http.ResponseWriter, r *http.Request)
+}
+
+type webhookHandlers struct {
+	storage storage.Webhook
+}
+
+func NewWebhookHandlers(storage storage.Webhook) WebhookHandlers {
+	return &webhookHandlers{storage: storage}
+}
+
+func (s *webhookHandlers) Register(r chi.Router) {
+	r.Post("/", s.PostCreateWebhook)
+	r.Get("/", s.GetWebhooks)
+	r.Get("/{webhook_id}", s.GetWebhook)
+	r.Delete("/{webhook_id}", s.DeleteWebhook)
+	r.Get("/{webhook_id}/deliveries", s.GetWebhookDeliveries)
+}
+
+func (s *webhookHandlers) PostCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if t != "application/json" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	var webhook model.Webhook
+	err = json.NewDecoder(r.Body).Decode(&webhook)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = webhook.Validate()
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	id, err := s.storage.CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	_, err = w.Write([]byte(id.String()))
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *webhookHandlers) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	webhooks, err := s.storage.GetWebhooks(r.Context())
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	err = json.NewEncoder(w).Encode(webhooks)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *webhookHandlers) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	webhook, err := s.storage.GetWebhook(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	webhook.Secret = ""
+
+	err = json.NewEncoder(w).Encode(webhook)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (s *webhookHandlers) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	err = s.storage.DeleteWebhook(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *webhookHandlers) GetWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	_, err = s.storage.GetWebhook(r.Context(), id)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	} else if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	deliveries, err := s.storage.GetWebhookDeliveries(r.Context(), id)
+	if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	err = json.NewEncoder(w).Encode(deliveries)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
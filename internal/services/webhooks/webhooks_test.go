@@ -0,0 +1,138 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeWebhooks struct {
+	storage.Webhook
+	webhooks map[uuid.UUID]model.Webhook
+	created  []model.Webhook
+}
+
+func (f *fakeWebhooks) CreateWebhook(_ context.Context, webhook model.Webhook) (uuid.UUID, error) {
+	f.created = append(f.created, webhook)
+	return uuid.New(), nil
+}
+
+func (f *fakeWebhooks) GetWebhook(_ context.Context, id uuid.UUID) (model.Webhook, error) {
+	webhook, ok := f.webhooks[id]
+	if !ok {
+		return model.Webhook{}, sql.ErrNoRows
+	}
+	return webhook, nil
+}
+
+func (f *fakeWebhooks) GetWebhooks(_ context.Context) ([]model.Webhook, error) {
+	webhooks := make([]model.Webhook, 0, len(f.webhooks))
+	for _, webhook := range f.webhooks {
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (f *fakeWebhooks) GetWebhookDeliveries(_ context.Context, _ uuid.UUID) ([]model.WebhookDelivery, error) {
+	return []model.WebhookDelivery{}, nil
+}
+
+func TestPostCreateWebhook(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{
+			name:        "valid",
+			contentType: "application/json",
+			body:        `{"url":"https://example.com/hook","events":["mail.sent","mail.opened"],"secret":"0123456789abcdef"}`,
+			wantStatus:  http.StatusCreated,
+		},
+		{
+			name:        "unknown event",
+			contentType: "application/json",
+			body:        `{"url":"https://example.com/hook","events":["mail.read"],"secret":"0123456789abcdef"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "short secret",
+			contentType: "application/json",
+			body:        `{"url":"https://example.com/hook","events":["mail.sent"],"secret":"short"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{
+			name:        "invalid url",
+			contentType: "application/json",
+			body:        `{"url":"not a url","events":["mail.sent"],"secret":"0123456789abcdef"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+		{name: "text", contentType: "text/plain", body: "https://example.com/hook", wantStatus: http.StatusUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			webhooks := &fakeWebhooks{}
+			r := chi.NewRouter()
+			NewWebhookHandlers(webhooks).Register(r)
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if created := len(webhooks.created) == 1; created != (tt.wantStatus == http.StatusCreated) {
+				t.Errorf("created = %+v", webhooks.created)
+			}
+		})
+	}
+}
+
+func TestGetWebhookHidesSecret(t *testing.T) {
+	id := uuid.New()
+	webhooks := &fakeWebhooks{webhooks: map[uuid.UUID]model.Webhook{
+		id: {ID: id, URL: "https://example.com/hook", Events: []string{model.EventMailSent}, Secret: "0123456789abcdef"},
+	}}
+	r := chi.NewRouter()
+	NewWebhookHandlers(webhooks).Register(r)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/", wantStatus: http.StatusOK},
+		{path: "/" + id.String(), wantStatus: http.StatusOK},
+		{path: "/" + id.String() + "/deliveries", wantStatus: http.StatusOK},
+		{path: "/" + uuid.NewString(), wantStatus: http.StatusNotFound},
+		{path: "/" + uuid.NewString() + "/deliveries", wantStatus: http.StatusNotFound},
+		{path: "/123", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("GET %s status = %d, want %d", tt.path, w.Code, tt.wantStatus)
+			continue
+		}
+		if strings.Contains(w.Body.String(), "0123456789abcdef") {
+			t.Errorf("GET %s exposes the secret: %s", tt.path, w.Body.String())
+		}
+		if tt.wantStatus == http.StatusOK && !json.Valid(w.Body.Bytes()) {
+			t.Errorf("GET %s body = %s", tt.path, w.Body.String())
+		}
+	}
+}
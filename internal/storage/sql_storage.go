@@ -2,10 +2,18 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"io"
+	"log"
 	"mail-service/internal/model"
+	"mail-service/internal/segment"
+	"strings"
 	"time"
 )
 
@@ -32,13 +40,14 @@ func (s *SqlStorage) Close() error {
 
 func (s *SqlStorage) CreateUser(ctx context.Context, user model.User) (uuid.UUID, error) {
 	result, err := s.db.NamedQueryContext(ctx, `
-		INSERT INTO users (email, first_name, last_name)
-		VALUES (:email, :first_name, :last_name)
+		INSERT INTO users (email, first_name, last_name, attributes, timezone)
+		VALUES (:email, :first_name, :last_name, :attributes, :timezone)
 		RETURNING id
 	`, user)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("can't create user: %w", err)
+		return uuid.Nil, fmt.Errorf("can't create user: %w", conflict(err))
 	}
+	defer result.Close()
 
 	var id uuid.UUID
 
@@ -47,43 +56,1351 @@ func (s *SqlStorage) CreateUser(ctx context.Context, user model.User) (uuid.UUID
 			return uuid.Nil, fmt.Errorf("can't get id: %w", err)
 		}
 	}
+	if err = result.Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create user: %w", conflict(err))
+	}
+
+	return id, nil
+}
+
+// conflict turns unique violations into ErrConflict.
+func conflict(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: %v", ErrConflict, err)
+	}
+	return err
+}
+
+func (s *SqlStorage) GetUser(ctx context.Context, id uuid.UUID) (model.User, error) {
+	var user model.User
+
+	if err := s.db.GetContext(ctx, &user, `
+		SELECT * FROM users WHERE id = $1
+	`, id); err != nil {
+		return model.User{}, fmt.Errorf("can't get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (s *SqlStorage) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
+	var user model.User
+
+	if err := s.db.GetContext(ctx, &user, `
+		SELECT * FROM users WHERE email = $1
+	`, email); err != nil {
+		return model.User{}, fmt.Errorf("can't get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// GetUsers returns a page of users ordered by creation time.
+func (s *SqlStorage) GetUsers(ctx context.Context, filter model.UserFilter) ([]model.User, error) {
+	var conditions []string
+	var args []interface{}
+
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if filter.EmailPrefix != "" {
+		conditions = append(conditions, fmt.Sprintf(`email LIKE %s || '%%'`, arg(escapeLike(filter.EmailPrefix))))
+	}
+	if filter.Name != "" {
+		conditions = append(conditions, fmt.Sprintf(
+			`(first_name || ' ' || last_name) ILIKE '%%' || %s || '%%'`, arg(escapeLike(filter.Name)),
+		))
+	}
+	if !filter.CreatedAfter.IsZero() {
+		conditions = append(conditions, fmt.Sprintf(`created_at >= %s`, arg(filter.CreatedAfter)))
+	}
+	if !filter.CreatedBefore.IsZero() {
+		conditions = append(conditions, fmt.Sprintf(`created_at < %s`, arg(filter.CreatedBefore)))
+	}
+	if filter.GroupID.Valid {
+		conditions = append(conditions, fmt.Sprintf(
+			`EXISTS (SELECT 1 FROM users_groups ug WHERE ug.user_id = users.id AND ug.group_id = %s)`, arg(filter.GroupID.UUID),
+		))
+	}
+
+	for name, value := range filter.Attributes {
+		conditions = append(conditions, fmt.Sprintf(`attributes ->> %s = %s`, arg(name), arg(value)))
+	}
+
+	query := `SELECT * FROM users`
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+	query += fmt.Sprintf(` ORDER BY created_at, id LIMIT %s OFFSET %s`, arg(filter.Limit), arg(filter.Offset))
+
+	users := []model.User{}
+
+	if err := s.db.SelectContext(ctx, &users, query, args...); err != nil {
+		return nil, fmt.Errorf("can't get users: %w", err)
+	}
+
+	return users, nil
+}
+
+// escapeLike escapes the LIKE wildcards so the value is matched literally.
+func escapeLike(value string) string {
+	return likeEscaper.Replace(value)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *SqlStorage) UpdateUser(ctx context.Context, user model.User) (model.User, error) {
+	var updated model.User
+
+	if err := s.db.GetContext(ctx, &updated, `
+		UPDATE users SET email = $1, first_name = $2, last_name = $3, attributes = $4, timezone = $5
+		WHERE id = $6
+		RETURNING *
+	`, user.Email, user.FirstName, user.LastName, user.Attributes, user.Timezone, user.ID); err != nil {
+		return model.User{}, fmt.Errorf("can't update user: %w", conflict(err))
+	}
+
+	return updated, nil
+}
+
+// DeleteUser removes the user, the user's mails, memberships and tracking
+// events are removed by the cascading foreign keys.
+func (s *SqlStorage) DeleteUser(ctx context.Context, id uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM users WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("can't delete user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't delete user: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// NormalizeEmails rewrites the emails stored before emails were normalized on
+// write, only emails which normalize could change are looked at. An email
+// whose normalized form belongs to another user is left as it is and
+// returned, the users have to be merged by hand.
+func (s *SqlStorage) NormalizeEmails(ctx context.Context, normalize func(string) string) (int, []string, error) {
+	var users []model.User
+	if err := s.db.SelectContext(ctx, &users, `
+		SELECT * FROM users
+		WHERE email <> LOWER(TRIM(email)) OR octet_length(email) <> char_length(email) OR email LIKE '%.'
+	`); err != nil {
+		return 0, nil, fmt.Errorf("can't get users to normalize: %w", err)
+	}
+
+	updated := 0
+	var duplicates []string
+	for _, user := range users {
+		email := normalize(user.Email)
+		if email == user.Email {
+			continue
+		}
+
+		_, err := s.db.ExecContext(ctx, `UPDATE users SET email = $1 WHERE id = $2`, email, user.ID)
+		if errors.Is(conflict(err), ErrConflict) {
+			duplicates = append(duplicates, user.Email)
+			continue
+		}
+		if err != nil {
+			return updated, duplicates, fmt.Errorf("can't normalize email: %w", err)
+		}
+		updated++
+	}
+
+	return updated, duplicates, nil
+}
+
+// ImportUsers copies the rows returned by next until io.EOF into a temporary
+// table and upserts the valid ones by email, optionally adding them to the
+// group. Existing users keep the fields a row omits and get its attributes
+// merged into theirs, the attribute defaults and CreateError only apply to
+// rows creating a user. The outcome of every row is passed to report once
+// the import is committed.
+func (s *SqlStorage) ImportUsers(ctx context.Context, groupID uuid.NullUUID, next func() (model.ImportRow, error), report func(model.ImportResult) error) error {
+	// The temporary table lives as long as the connection, so the whole
+	// import has to use the same one.
+	conn, err := s.db.Connx(ctx)
+	if err != nil {
+		return fmt.Errorf("can't get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `
+		CREATE TEMP TABLE user_import (
+			row_num INTEGER NOT NULL,
+			email TEXT,
+			first_name TEXT,
+			last_name TEXT,
+			attributes JSONB,
+			defaults JSONB,
+			timezone TEXT,
+			create_error TEXT,
+			error TEXT,
+			user_id uuid,
+			created BOOLEAN
+		)
+	`); err != nil {
+		return fmt.Errorf("can't create import table: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), `DROP TABLE IF EXISTS user_import`); err != nil {
+			log.Printf("can't drop import table: %v", err)
+		}
+	}()
+
+	tx, err := conn.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = copyImportRows(ctx, tx, next); err != nil {
+		return err
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE user_import i SET error = i.create_error
+		WHERE i.error IS NULL AND i.create_error IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM users u WHERE u.email = i.email)
+	`); err != nil {
+		return fmt.Errorf("can't check new users: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		WITH upserted AS (
+			INSERT INTO users (email, first_name, last_name, attributes, timezone)
+			SELECT DISTINCT ON (i.email) i.email,
+				COALESCE(i.first_name, u.first_name, ''),
+				COALESCE(i.last_name, u.last_name, ''),
+				CASE WHEN u.id IS NULL THEN i.defaults || i.attributes ELSE i.attributes END,
+				COALESCE(i.timezone, u.timezone, 'UTC')
+			FROM user_import i
+			LEFT JOIN users u ON u.email = i.email
+			WHERE i.error IS NULL
+			ORDER BY i.email, i.row_num DESC
+			ON CONFLICT (email) DO UPDATE
+			SET first_name = EXCLUDED.first_name, last_name = EXCLUDED.last_name,
+				attributes = users.attributes || EXCLUDED.attributes, timezone = EXCLUDED.timezone
+			RETURNING id, email, xmax = 0 AS created
+		)
+		UPDATE user_import i SET user_id = u.id, created = u.created
+		FROM upserted u
+		WHERE i.email = u.email AND i.error IS NULL
+	`); err != nil {
+		return fmt.Errorf("can't upsert users: %w", err)
+	}
+
+	if groupID.Valid {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO users_groups (user_id, group_id)
+			SELECT DISTINCT user_id, $1::uuid FROM user_import WHERE user_id IS NOT NULL
+			ON CONFLICT DO NOTHING
+		`, groupID.UUID); err != nil {
+			return fmt.Errorf("can't add users to group: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	rows, err := conn.QueryxContext(ctx, `
+		SELECT row_num, user_id, COALESCE(error, '') AS error, CASE
+			WHEN error IS NOT NULL THEN 'invalid'
+			WHEN created THEN 'created'
+			ELSE 'updated'
+		END AS status
+		FROM user_import ORDER BY row_num
+	`)
+	if err != nil {
+		return fmt.Errorf("can't get import report: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var result model.ImportResult
+		if err = rows.StructScan(&result); err != nil {
+			return fmt.Errorf("can't scan import result: %w", err)
+		}
+		if err = report(result); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func copyImportRows(ctx context.Context, tx *sqlx.Tx, next func() (model.ImportRow, error)) error {
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("user_import", "row_num", "email", "first_name", "last_name", "attributes", "defaults", "timezone", "create_error", "error"))
+	if err != nil {
+		return fmt.Errorf("can't start copy: %w", err)
+	}
+	defer stmt.Close()
+
+	for {
+		row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if row.Error != "" {
+			_, err = stmt.ExecContext(ctx, row.Row, nil, nil, nil, nil, nil, nil, nil, row.Error)
+		} else {
+			var attributes, defaults string
+			attributes, err = importAttributes(row.User.Attributes)
+			if err != nil {
+				return err
+			}
+			defaults, err = importAttributes(row.Defaults)
+			if err != nil {
+				return err
+			}
+			var createError interface{}
+			if row.CreateError != "" {
+				createError = row.CreateError
+			}
+			_, err = stmt.ExecContext(
+				ctx, row.Row, row.User.Email,
+				importField(row, "first_name", row.User.FirstName),
+				importField(row, "last_name", row.User.LastName),
+				attributes,
+				defaults,
+				importField(row, "timezone", row.User.Timezone),
+				createError,
+				nil,
+			)
+		}
+		if err != nil {
+			return fmt.Errorf("can't copy row: %w", err)
+		}
+	}
+
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		return fmt.Errorf("can't finish copy: %w", err)
+	}
+	return nil
+}
+
+// importAttributes marshals the attributes for the copy, copied []byte
+// values are sent as bytea and JSONB needs text.
+func importAttributes(attributes model.Attributes) (string, error) {
+	if attributes == nil {
+		attributes = model.Attributes{}
+	}
+	data, err := json.Marshal(attributes)
+	if err != nil {
+		return "", fmt.Errorf("can't marshal attributes: %w", err)
+	}
+	return string(data), nil
+}
+
+// importField copies the omitted fields as NULL, so the upsert keeps the
+// stored values.
+func importField(row model.ImportRow, name, value string) interface{} {
+	if row.Omitted[name] {
+		return nil
+	}
+	return value
+}
+
+// ExportUser collects everything stored about the user in one snapshot.
+func (s *SqlStorage) ExportUser(ctx context.Context, id uuid.UUID) (model.UserArchive, error) {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return model.UserArchive{}, fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	archive := model.UserArchive{
+		Groups:               []model.GroupSubscription{},
+		PreferenceChanges:    []model.PreferenceChange{},
+		SubscriptionRequests: []model.SubscriptionRequest{},
+		Mails:                []model.Mail{},
+		OpenEvents:           []model.OpenEvent{},
+		ClickEvents:          []model.ClickEvent{},
+		Bounces:              []model.Bounce{},
+		Complaints:           []model.Complaint{},
+		Suppressions:         []model.Suppression{},
+		ExportedAt:           time.Now().UTC(),
+	}
+
+	if err = tx.GetContext(ctx, &archive.User, `
+		SELECT * FROM users WHERE id = $1
+	`, id); err != nil {
+		return model.UserArchive{}, fmt.Errorf("can't get user: %w", err)
+	}
+
+	for _, part := range []struct {
+		dest  interface{}
+		query string
+	}{
+		{&archive.Groups, groupSubscriptionsQuery},
+		{&archive.PreferenceChanges, `SELECT * FROM preference_changes WHERE user_id = $1 ORDER BY created_at`},
+		{&archive.SubscriptionRequests, `SELECT * FROM subscription_requests WHERE user_id = $1 ORDER BY created_at`},
+		{&archive.Mails, `SELECT * FROM mails WHERE to_user_id = $1 ORDER BY created_at`},
+		{&archive.OpenEvents, `
+			SELECT e.* FROM open_events e
+			INNER JOIN mails m ON m.id = e.mail_id
+			WHERE m.to_user_id = $1 ORDER BY e.opened_at
+		`},
+		{&archive.ClickEvents, `
+			SELECT e.* FROM click_events e
+			INNER JOIN mails m ON m.id = e.mail_id
+			WHERE m.to_user_id = $1 ORDER BY e.clicked_at
+		`},
+		{&archive.Bounces, `
+			SELECT b.* FROM bounces b
+			INNER JOIN mails m ON m.id = b.mail_id
+			WHERE m.to_user_id = $1 ORDER BY b.created_at
+		`},
+		{&archive.Complaints, `
+			SELECT c.* FROM complaints c
+			INNER JOIN mails m ON m.id = c.mail_id
+			WHERE m.to_user_id = $1 ORDER BY c.created_at
+		`},
+	} {
+		if err = tx.SelectContext(ctx, part.dest, part.query, id); err != nil {
+			return model.UserArchive{}, fmt.Errorf("can't export user: %w", err)
+		}
+	}
+
+	if err = tx.SelectContext(ctx, &archive.Suppressions, `
+		SELECT * FROM suppressions WHERE address IN (LOWER($1), $2)
+	`, archive.User.Email, model.HashAddress(archive.User.Email)); err != nil {
+		return model.UserArchive{}, fmt.Errorf("can't export user: %w", err)
+	}
+
+	return archive, nil
+}
+
+// EraseUser deletes the user with everything related to them and keeps only
+// the hash of the address on the suppression list. It returns the user's mails
+// which were still waiting to be sent.
+func (s *SqlStorage) EraseUser(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error) {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var email string
+	if err = tx.GetContext(ctx, &email, `
+		SELECT email FROM users WHERE id = $1 FOR UPDATE
+	`, id); err != nil {
+		return nil, fmt.Errorf("can't get user: %w", err)
+	}
+
+	var pending []uuid.UUID
+	if err = tx.SelectContext(ctx, &pending, `
+		SELECT id FROM mails WHERE to_user_id = $1 AND status IN ('pending', 'deferred')
+	`, id); err != nil {
+		return nil, fmt.Errorf("can't get pending mails: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM suppressions WHERE address = LOWER($1)
+	`, email); err != nil {
+		return nil, fmt.Errorf("can't remove suppression: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO suppressions (address, reason, source)
+		VALUES ($1, $2, 'api')
+		ON CONFLICT (address) DO NOTHING
+	`, model.HashAddress(email), model.SuppressionErasure); err != nil {
+		return nil, fmt.Errorf("can't add suppression: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM users WHERE id = $1
+	`, id); err != nil {
+		return nil, fmt.Errorf("can't delete user: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	return pending, nil
+}
+
+// exportBatchSize is the number of rows fetched from an export cursor at once.
+const exportBatchSize = 1000
+
+func (s *SqlStorage) ExportUsers(ctx context.Context, fn func(model.UserExport) error) error {
+	return s.export(ctx, `
+		SELECT u.*, ARRAY(
+			SELECT g.name FROM users_groups ug
+			INNER JOIN groups g ON g.id = ug.group_id
+			WHERE ug.user_id = u.id
+			ORDER BY g.name
+		) AS groups
+		FROM users u
+		ORDER BY u.created_at, u.id
+	`, nil, func(rows *sqlx.Rows) error {
+		var user model.UserExport
+		if err := rows.StructScan(&user); err != nil {
+			return fmt.Errorf("can't scan user: %w", err)
+		}
+		return fn(user)
+	})
+}
+
+func (s *SqlStorage) ExportGroups(ctx context.Context, fn func(model.GroupInfo) error) error {
+	return s.export(ctx, `
+		SELECT g.*, (SELECT COUNT(*) FROM users_groups ug WHERE ug.group_id = g.id) AS members
+		FROM groups g
+		ORDER BY g.created_at, g.id
+	`, nil, func(rows *sqlx.Rows) error {
+		var group model.GroupInfo
+		if err := rows.StructScan(&group); err != nil {
+			return fmt.Errorf("can't scan group: %w", err)
+		}
+		return fn(group)
+	})
+}
+
+// ExportMails streams the mails created in [from, to).
+func (s *SqlStorage) ExportMails(ctx context.Context, from, to time.Time, fn func(model.MailExport) error) error {
+	return s.export(ctx, `
+		SELECT
+			m.id, m.to_user_id, u.email, m.group_id, m.template, m.category, m.subject, m.status, m.error,
+			m.created_at, m.sent_at, m.first_opened_at, m.open_count,
+			c.first_clicked_at, c.click_count,
+			EXISTS (SELECT 1 FROM complaints cm WHERE cm.mail_id = m.id) AS complained
+		FROM mails m
+		INNER JOIN users u ON u.id = m.to_user_id
+		LEFT JOIN LATERAL (
+			SELECT MIN(ce.clicked_at) AS first_clicked_at, COUNT(*) AS click_count
+			FROM click_events ce WHERE ce.mail_id = m.id
+		) c ON TRUE
+		WHERE m.created_at >= $1 AND m.created_at < $2
+		ORDER BY m.created_at, m.id
+	`, []interface{}{from, to}, func(rows *sqlx.Rows) error {
+		var mail model.MailExport
+		if err := rows.StructScan(&mail); err != nil {
+			return fmt.Errorf("can't scan mail: %w", err)
+		}
+		return fn(mail)
+	})
+}
+
+// export runs the query through a server side cursor so only a batch of rows
+// is held in memory at a time.
+func (s *SqlStorage) export(ctx context.Context, query string, args []interface{}, row func(*sqlx.Rows) error) error {
+	tx, err := s.db.BeginTxx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, `DECLARE export NO SCROLL CURSOR FOR `+query, args...); err != nil {
+		return fmt.Errorf("can't declare cursor: %w", err)
+	}
+
+	for {
+		n, err := fetch(ctx, tx, row)
+		if err != nil {
+			return err
+		}
+		if n < exportBatchSize {
+			break
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+	return nil
+}
+
+func fetch(ctx context.Context, tx *sqlx.Tx, row func(*sqlx.Rows) error) (int, error) {
+	rows, err := tx.QueryxContext(ctx, fmt.Sprintf(`FETCH %d FROM export`, exportBatchSize))
+	if err != nil {
+		return 0, fmt.Errorf("can't fetch rows: %w", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		n++
+		if err = row(rows); err != nil {
+			return n, err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return n, fmt.Errorf("can't fetch rows: %w", err)
+	}
+	return n, nil
+}
+
+// CreateAttributeDefinition creates the definition or replaces the existing
+// one with the same name.
+func (s *SqlStorage) CreateAttributeDefinition(ctx context.Context, definition model.AttributeDefinition) error {
+	if _, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO attribute_definitions (name, type, required, default_value)
+		VALUES (:name, :type, :required, :default_value)
+		ON CONFLICT (name) DO UPDATE
+		SET type = EXCLUDED.type, required = EXCLUDED.required, default_value = EXCLUDED.default_value
+	`, definition); err != nil {
+		return fmt.Errorf("can't create attribute definition: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) GetAttributeDefinitions(ctx context.Context) ([]model.AttributeDefinition, error) {
+	definitions := []model.AttributeDefinition{}
+
+	if err := s.db.SelectContext(ctx, &definitions, `
+		SELECT * FROM attribute_definitions ORDER BY name
+	`); err != nil {
+		return nil, fmt.Errorf("can't get attribute definitions: %w", err)
+	}
+
+	return definitions, nil
+}
+
+func (s *SqlStorage) DeleteAttributeDefinition(ctx context.Context, name string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM attribute_definitions WHERE name = $1
+	`, name)
+	if err != nil {
+		return fmt.Errorf("can't delete attribute definition: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't delete attribute definition: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) CreateSegment(ctx context.Context, segment model.Segment) (uuid.UUID, error) {
+	var id uuid.UUID
+
+	if err := s.db.GetContext(ctx, &id, `
+		INSERT INTO segments (name, expression)
+		VALUES ($1, $2)
+		RETURNING id
+	`, segment.Name, segment.Expression); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create segment: %w", conflict(err))
+	}
+
+	return id, nil
+}
+
+func (s *SqlStorage) GetSegment(ctx context.Context, id uuid.UUID) (model.Segment, error) {
+	var segment model.Segment
+
+	if err := s.db.GetContext(ctx, &segment, `
+		SELECT * FROM segments WHERE id = $1
+	`, id); err != nil {
+		return model.Segment{}, fmt.Errorf("can't get segment: %w", err)
+	}
+
+	return segment, nil
+}
+
+func (s *SqlStorage) GetSegments(ctx context.Context) ([]model.Segment, error) {
+	segments := []model.Segment{}
+
+	if err := s.db.SelectContext(ctx, &segments, `
+		SELECT * FROM segments ORDER BY name
+	`); err != nil {
+		return nil, fmt.Errorf("can't get segments: %w", err)
+	}
+
+	return segments, nil
+}
+
+func (s *SqlStorage) DeleteSegment(ctx context.Context, id uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM segments WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("can't delete segment: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't delete segment: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+// PreviewSegment counts the users of the segment and returns the first of
+// them by creation time.
+func (s *SqlStorage) PreviewSegment(ctx context.Context, expr segment.Expr, sample int) (model.SegmentPreview, error) {
+	var args []interface{}
+	condition, err := compileSegment(expr, func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	})
+	if err != nil {
+		return model.SegmentPreview{}, fmt.Errorf("can't compile segment: %w", err)
+	}
+
+	preview := model.SegmentPreview{Users: []model.User{}}
+
+	if err = s.db.GetContext(ctx, &preview.Count, `SELECT COUNT(*) FROM users WHERE `+condition, args...); err != nil {
+		return model.SegmentPreview{}, fmt.Errorf("can't count segment: %w", err)
+	}
+
+	args = append(args, sample)
+	if err = s.db.SelectContext(ctx, &preview.Users, fmt.Sprintf(
+		`SELECT * FROM users WHERE %s ORDER BY created_at, id LIMIT $%d`, condition, len(args),
+	), args...); err != nil {
+		return model.SegmentPreview{}, fmt.Errorf("can't get segment users: %w", err)
+	}
+
+	return preview, nil
+}
+
+func (s *SqlStorage) GetUsersBySegment(ctx context.Context, expr segment.Expr) ([]model.User, error) {
+	var args []interface{}
+	condition, err := compileSegment(expr, func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can't compile segment: %w", err)
+	}
+
+	var users []model.User
+
+	if err = s.db.SelectContext(ctx, &users, `SELECT * FROM users WHERE `+condition, args...); err != nil {
+		return nil, fmt.Errorf("can't get users by segment: %w", err)
+	}
+
+	return users, nil
+}
+
+// segmentColumns maps the user fields of segment expressions to the columns.
+var segmentColumns = map[string]string{
+	segment.FieldEmail:     "users.email",
+	segment.FieldFirstName: "users.first_name",
+	segment.FieldLastName:  "users.last_name",
+	segment.FieldTimezone:  "users.timezone",
+	segment.FieldCreatedAt: "users.created_at",
+}
+
+// compileSegment turns the expression into a condition on the users table,
+// arg adds a query argument and returns its placeholder. Every condition is
+// either true or false, never NULL, so NOT works on missing attributes too.
+func compileSegment(expr segment.Expr, arg func(interface{}) string) (string, error) {
+	switch e := expr.(type) {
+	case segment.And:
+		return compileBinary(e.Left, "AND", e.Right, arg)
+	case segment.Or:
+		return compileBinary(e.Left, "OR", e.Right, arg)
+	case segment.Not:
+		condition, err := compileSegment(e.Expr, arg)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("NOT (%s)", condition), nil
+	case segment.Compare:
+		if !e.Attribute {
+			return fmt.Sprintf("%s %s %s", segmentColumns[e.Name], e.Op, arg(e.Value)), nil
+		}
+
+		// Attributes are compared as JSON, values of another type never
+		// match instead of failing a cast.
+		value, err := json.Marshal(e.Value)
+		if err != nil {
+			return "", err
+		}
+		attr := fmt.Sprintf("users.attributes -> %s::text", arg(e.Name))
+		v := arg(string(value)) + "::jsonb"
+		switch e.Op {
+		case "=":
+			return fmt.Sprintf("COALESCE(%s = %s, FALSE)", attr, v), nil
+		case "!=":
+			return fmt.Sprintf("%s IS DISTINCT FROM %s", attr, v), nil
+		default:
+			return fmt.Sprintf(
+				"COALESCE(jsonb_typeof(%s) = jsonb_typeof(%s) AND %s %s %s, FALSE)", attr, v, attr, e.Op, v,
+			), nil
+		}
+	case segment.InGroup:
+		// Members of the groups included by the named one are its members
+		// too.
+		return fmt.Sprintf(`EXISTS (
+			WITH RECURSIVE included(id) AS (
+				SELECT id FROM groups WHERE name = %s
+				UNION
+				SELECT gi.included_group_id FROM group_includes gi INNER JOIN included i ON gi.group_id = i.id
+			)
+			SELECT 1 FROM users_groups ug INNER JOIN included i ON i.id = ug.group_id
+			WHERE ug.user_id = users.id
+		)`, arg(e.Name)), nil
+	case segment.Engaged:
+		since := fmt.Sprintf("NOW() - make_interval(secs => %s)", arg(e.Within.Seconds()))
+		switch e.Kind {
+		case segment.Opened:
+			return fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM mails m INNER JOIN open_events oe ON oe.mail_id = m.id
+				WHERE m.to_user_id = users.id AND NOT oe.automated AND oe.opened_at >= %s
+			)`, since), nil
+		case segment.Clicked:
+			return fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM mails m INNER JOIN click_events ce ON ce.mail_id = m.id
+				WHERE m.to_user_id = users.id AND ce.clicked_at >= %s
+			)`, since), nil
+		case segment.Received:
+			return fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM mails m
+				WHERE m.to_user_id = users.id AND m.sent_at >= %s
+			)`, since), nil
+		}
+	}
+
+	return "", fmt.Errorf("unsupported segment expression %T", expr)
+}
+
+func compileBinary(left segment.Expr, op string, right segment.Expr, arg func(interface{}) string) (string, error) {
+	l, err := compileSegment(left, arg)
+	if err != nil {
+		return "", err
+	}
+	r, err := compileSegment(right, arg)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(%s %s %s)", l, op, r), nil
+}
+
+func (s *SqlStorage) CreateGroup(ctx context.Context, group model.Group) (uuid.UUID, error) {
+	result, err := s.db.NamedQueryContext(ctx, `
+		INSERT INTO groups (name, double_opt_in)
+		VALUES (:name, :double_opt_in)
+		RETURNING id
+	`, group)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("can't create group: %w", conflict(err))
+	}
+	defer result.Close()
+
+	var id uuid.UUID
+
+	if result.Next() {
+		if err = result.Scan(&id); err != nil {
+			return uuid.Nil, fmt.Errorf("can't get id: %w", err)
+		}
+	}
+	if err = result.Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create group: %w", conflict(err))
+	}
+
+	return id, nil
+}
+
+func (s *SqlStorage) GetGroupById(ctx context.Context, id uuid.UUID) (model.Group, error) {
+	var group model.Group
+
+	if err := s.db.GetContext(ctx, &group, `
+		SELECT * FROM groups WHERE id = $1
+	`, id); err != nil {
+		return model.Group{}, fmt.Errorf("can't get group: %w", err)
+	}
+
+	return group, nil
+}
+
+func (s *SqlStorage) GetGroupByName(ctx context.Context, name string) (model.Group, error) {
+	var group model.Group
+
+	if err := s.db.GetContext(ctx, &group, `
+		SELECT * FROM groups WHERE name = $1
+	`, name); err != nil {
+		return model.Group{}, fmt.Errorf("can't get group: %w", err)
+	}
+
+	return group, nil
+}
+
+// GetGroups returns a page of groups ordered by name with their member
+// counts.
+func (s *SqlStorage) GetGroups(ctx context.Context, limit, offset int) ([]model.GroupInfo, error) {
+	groups := []model.GroupInfo{}
+
+	if err := s.db.SelectContext(ctx, &groups, `
+		SELECT g.*, (SELECT COUNT(*) FROM users_groups ug WHERE ug.group_id = g.id) AS members
+		FROM groups g
+		ORDER BY g.name
+		LIMIT $1 OFFSET $2
+	`, limit, offset); err != nil {
+		return nil, fmt.Errorf("can't get groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+// DeleteGroup removes the group and its memberships, the mails sent to the
+// group are kept without it.
+func (s *SqlStorage) DeleteGroup(ctx context.Context, id uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM groups WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("can't delete group: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't delete group: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) AddUserToGroup(ctx context.Context, userID, groupID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO users_groups (user_id, group_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, userID, groupID); err != nil {
+		return fmt.Errorf("can't add user to group: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) RemoveUserFromGroup(ctx context.Context, userID, groupID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM users_groups WHERE user_id = $1 AND group_id = $2
+	`, userID, groupID); err != nil {
+		return fmt.Errorf("can't remove user from group: %w", err)
+	}
+
+	return nil
+}
+
+// membershipInput resolves the users of a bulk membership change, $2 holds
+// the user ids and $3 the emails, one of them is empty in every row.
+const membershipInput = `
+	input AS (
+		SELECT t.n, t.user_id AS ref_id, t.email AS ref_email, u.id
+		FROM unnest($2::text[], $3::text[]) WITH ORDINALITY AS t(user_id, email, n)
+		LEFT JOIN users u ON u.id = NULLIF(t.user_id, '')::uuid OR u.email = NULLIF(t.email, '')
+	)
+`
+
+// membershipResults reports the change of every input row, changed holds the
+// ids of the users whose membership was changed.
+const membershipResults = `
+	SELECT COALESCE(i.id, NULLIF(i.ref_id, '')::uuid) AS user_id, i.ref_email AS email,
+		CASE WHEN i.id IS NULL THEN 'not_found' WHEN c.user_id IS NOT NULL THEN $4 ELSE $5 END AS status
+	FROM input i
+	LEFT JOIN (SELECT DISTINCT user_id FROM changed) c ON c.user_id = i.id
+	ORDER BY i.n
+`
+
+// AddUsersToGroup adds the users in one transaction, users who are already
+// members are reported as such.
+func (s *SqlStorage) AddUsersToGroup(ctx context.Context, groupID uuid.UUID, change model.MembershipChange) ([]model.MembershipResult, error) {
+	results, err := s.changeMembership(ctx, groupID, change, `
+		changed AS (
+			INSERT INTO users_groups (user_id, group_id)
+			SELECT DISTINCT id, $1::uuid FROM input WHERE id IS NOT NULL
+			ON CONFLICT DO NOTHING
+			RETURNING user_id
+		)
+	`, model.MembershipAdded, model.MembershipExists)
+	if err != nil {
+		return nil, fmt.Errorf("can't add users to group: %w", err)
+	}
+
+	return results, nil
+}
+
+// RemoveUsersFromGroup removes the users in one transaction, users who
+// aren't members are reported as such.
+func (s *SqlStorage) RemoveUsersFromGroup(ctx context.Context, groupID uuid.UUID, change model.MembershipChange) ([]model.MembershipResult, error) {
+	results, err := s.changeMembership(ctx, groupID, change, `
+		changed AS (
+			DELETE FROM users_groups ug USING input i
+			WHERE ug.group_id = $1 AND ug.user_id = i.id
+			RETURNING ug.user_id
+		)
+	`, model.MembershipRemoved, model.MembershipNotMember)
+	if err != nil {
+		return nil, fmt.Errorf("can't remove users from group: %w", err)
+	}
+
+	return results, nil
+}
+
+func (s *SqlStorage) changeMembership(ctx context.Context, groupID uuid.UUID, change model.MembershipChange, changed, yes, no string) ([]model.MembershipResult, error) {
+	ids := make(pq.StringArray, 0, len(change.UserIDs)+len(change.Emails))
+	emails := make(pq.StringArray, 0, cap(ids))
+	for _, id := range change.UserIDs {
+		ids = append(ids, id.String())
+		emails = append(emails, "")
+	}
+	for _, email := range change.Emails {
+		ids = append(ids, "")
+		emails = append(emails, email)
+	}
+
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can't begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The group is locked so it isn't deleted in the middle of the change.
+	var locked uuid.UUID
+	if err = tx.GetContext(ctx, &locked, `
+		SELECT id FROM groups WHERE id = $1 FOR SHARE
+	`, groupID); err != nil {
+		return nil, fmt.Errorf("can't get group: %w", err)
+	}
+
+	results := []model.MembershipResult{}
+	if err = tx.SelectContext(ctx, &results, `WITH `+membershipInput+`, `+changed+membershipResults,
+		groupID, ids, emails, yes, no,
+	); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("can't commit tx: %w", err)
+	}
+
+	return results, nil
+}
+
+// GetUsersByGroup returns a page of the group members ordered by id, the page
+// starts after the given user.
+func (s *SqlStorage) GetUsersByGroup(ctx context.Context, groupID uuid.UUID, after uuid.NullUUID, limit int) ([]model.User, error) {
+	users := []model.User{}
+
+	if err := s.db.SelectContext(ctx, &users, `
+		SELECT u.* FROM users u
+		INNER JOIN users_groups ug ON u.id = ug.user_id
+		WHERE ug.group_id = $1 AND ($2::uuid IS NULL OR ug.user_id > $2)
+		ORDER BY ug.user_id
+		LIMIT $3
+	`, groupID, after, limit); err != nil {
+		return nil, fmt.Errorf("can't get users by group: %w", err)
+	}
+
+	return users, nil
+}
+
+// includedGroups is the group given by $1 with all the groups it includes,
+// directly or through other groups. UNION stops the recursion on a group
+// which was already visited.
+const includedGroups = `
+	included(id) AS (
+		SELECT $1::uuid
+		UNION
+		SELECT gi.included_group_id FROM group_includes gi INNER JOIN included i ON gi.group_id = i.id
+	)
+`
+
+// GetRecipientsByGroup returns the members of the group and of the groups it
+// includes, each user once. Users who unsubscribed from the group or from
+// the group they are a member of are skipped, as well as the members of the
+// excluded groups and of the groups they include.
+func (s *SqlStorage) GetRecipientsByGroup(ctx context.Context, groupID uuid.UUID, exclude []uuid.UUID) ([]model.User, error) {
+	excluded := make(pq.StringArray, 0, len(exclude))
+	for _, id := range exclude {
+		excluded = append(excluded, id.String())
+	}
+
+	var users []model.User
+
+	if err := s.db.SelectContext(ctx, &users, `
+		WITH RECURSIVE `+includedGroups+`, excluded(id) AS (
+			SELECT unnest($2::uuid[])
+			UNION
+			SELECT gi.included_group_id FROM group_includes gi INNER JOIN excluded e ON gi.group_id = e.id
+		)
+		SELECT u.* FROM users u
+		WHERE EXISTS (
+			SELECT 1 FROM users_groups ug INNER JOIN included i ON i.id = ug.group_id
+			WHERE ug.user_id = u.id AND NOT EXISTS (
+				SELECT 1 FROM group_unsubscribes gu WHERE gu.user_id = u.id AND gu.group_id = ug.group_id
+			)
+		) AND NOT EXISTS (
+			SELECT 1 FROM group_unsubscribes gu WHERE gu.user_id = u.id AND gu.group_id = $1
+		) AND NOT EXISTS (
+			SELECT 1 FROM users_groups ug INNER JOIN excluded e ON e.id = ug.group_id WHERE ug.user_id = u.id
+		)
+	`, groupID, excluded); err != nil {
+		return nil, fmt.Errorf("can't get recipients by group: %w", err)
+	}
+
+	return users, nil
+}
+
+// IncludeGroup makes the members of the included group members of the
+// group, it fails with ErrCycle if the included group already includes the
+// group.
+func (s *SqlStorage) IncludeGroup(ctx context.Context, groupID, includedID uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Concurrent inclusions could make a cycle together while each of them
+	// alone doesn't, so they are serialized.
+	if _, err = tx.ExecContext(ctx, `LOCK TABLE group_includes IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("can't lock group includes: %w", err)
+	}
+
+	var cycle bool
+	if err = tx.GetContext(ctx, &cycle, `
+		WITH RECURSIVE `+includedGroups+`
+		SELECT EXISTS (SELECT 1 FROM included WHERE id = $2)
+	`, includedID, groupID); err != nil {
+		return fmt.Errorf("can't check group includes: %w", err)
+	}
+	if cycle {
+		return fmt.Errorf("can't include group: %w", ErrCycle)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO group_includes (group_id, included_group_id)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, groupID, includedID); err != nil {
+		return fmt.Errorf("can't include group: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit tx: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) RemoveIncludedGroup(ctx context.Context, groupID, includedID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM group_includes WHERE group_id = $1 AND included_group_id = $2
+	`, groupID, includedID); err != nil {
+		return fmt.Errorf("can't remove included group: %w", err)
+	}
+
+	return nil
+}
+
+// GetIncludedGroups returns the groups the group includes directly.
+func (s *SqlStorage) GetIncludedGroups(ctx context.Context, groupID uuid.UUID) ([]model.Group, error) {
+	groups := []model.Group{}
+
+	if err := s.db.SelectContext(ctx, &groups, `
+		SELECT g.* FROM groups g
+		INNER JOIN group_includes gi ON gi.included_group_id = g.id
+		WHERE gi.group_id = $1
+		ORDER BY g.name
+	`, groupID); err != nil {
+		return nil, fmt.Errorf("can't get included groups: %w", err)
+	}
+
+	return groups, nil
+}
+
+func (s *SqlStorage) CreateMail(ctx context.Context, mail model.Mail) (uuid.UUID, error) {
+	result, err := s.db.NamedQueryContext(ctx, `
+		INSERT INTO mails (subject, body, to_user_id, group_id, segment_id, template, priority, category)
+		VALUES (:subject, :body, :to_user_id, :group_id, :segment_id, :template, :priority, :category)
+		RETURNING id
+	`, mail)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("can't create mail: %w", err)
+	}
+	defer result.Close()
+
+	var id uuid.UUID
+
+	if result.Next() {
+		if err = result.Scan(&id); err != nil {
+			return uuid.Nil, fmt.Errorf("can't get id: %w", err)
+		}
+	}
+	if err = result.Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create mail: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *SqlStorage) MarkAsSent(ctx context.Context, mailID uuid.UUID, time time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE mails SET sent_at = $1, status = 'sent' WHERE id = $2
+	`, time, mailID); err != nil {
+		return fmt.Errorf("can't mark as sent: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) MarkAsFailed(ctx context.Context, mailID uuid.UUID, reason string) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE mails SET status = 'failed', error = $1 WHERE id = $2
+	`, reason, mailID); err != nil {
+		return fmt.Errorf("can't mark as failed: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) MarkAsSuppressed(ctx context.Context, mailID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE mails SET status = 'suppressed' WHERE id = $1
+	`, mailID); err != nil {
+		return fmt.Errorf("can't mark as suppressed: %w", err)
+	}
+
+	return nil
+}
+
+// MarkAsDeferred records that the mail is held back until the given time.
+func (s *SqlStorage) MarkAsDeferred(ctx context.Context, mailID uuid.UUID, until time.Time) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE mails SET status = 'deferred', deferred_until = $1 WHERE id = $2
+	`, until.UTC(), mailID); err != nil {
+		return fmt.Errorf("can't mark as deferred: %w", err)
+	}
+
+	return nil
+}
 
-	return id, nil
+func (s *SqlStorage) MarkAsCapped(ctx context.Context, mailID uuid.UUID) error {
+	if _, err := s.db.ExecContext(ctx, `
+		UPDATE mails SET status = 'capped' WHERE id = $1
+	`, mailID); err != nil {
+		return fmt.Errorf("can't mark as capped: %w", err)
+	}
+
+	return nil
 }
 
-func (s *SqlStorage) GetUser(ctx context.Context, id uuid.UUID) (model.User, error) {
-	var user model.User
+// CountSentMails returns the number of mails of the category sent to the user
+// since the given time, mails which bounced later count too.
+func (s *SqlStorage) CountSentMails(ctx context.Context, userID uuid.UUID, category string, since time.Time) (int, error) {
+	var count int
 
-	if err := s.db.GetContext(ctx, &user, `
-		SELECT * FROM users WHERE id = $1
+	if err := s.db.GetContext(ctx, &count, `
+		SELECT COUNT(*) FROM mails
+		WHERE to_user_id = $1 AND category = $2 AND sent_at >= $3
+	`, userID, category, since.UTC()); err != nil {
+		return 0, fmt.Errorf("can't count sent mails: %w", err)
+	}
+
+	return count, nil
+}
+
+func (s *SqlStorage) GetMailById(ctx context.Context, id uuid.UUID) (model.Mail, error) {
+	var mail model.Mail
+
+	if err := s.db.GetContext(ctx, &mail, `
+		SELECT * FROM mails WHERE id = $1
 	`, id); err != nil {
-		return model.User{}, fmt.Errorf("can't get user: %w", err)
+		return model.Mail{}, fmt.Errorf("can't get mail: %w", err)
 	}
 
-	return user, nil
+	return mail, nil
 }
 
-func (s *SqlStorage) GetUserByEmail(ctx context.Context, email string) (model.User, error) {
-	var user model.User
+func (s *SqlStorage) GetMailsBySentTo(ctx context.Context, receiverID uuid.UUID) ([]model.Mail, error) {
+	var mails []model.Mail
 
-	if err := s.db.GetContext(ctx, &user, `
-		SELECT * FROM users WHERE email = $1
-	`, email); err != nil {
-		return model.User{}, fmt.Errorf("can't get user: %w", err)
+	if err := s.db.SelectContext(ctx, &mails, `
+		SELECT * FROM mails WHERE to_user_id = $1
+	`, receiverID); err != nil {
+		return nil, fmt.Errorf("can't get mails by receiver: %w", err)
 	}
 
-	return user, nil
+	return mails, nil
 }
 
-func (s *SqlStorage) CreateGroup(ctx context.Context, group model.Group) (uuid.UUID, error) {
+func (s *SqlStorage) GetMailWithUser(ctx context.Context, id uuid.UUID) (model.MailWithUser, error) {
+	var mail model.MailWithUser
+
+	if err := s.db.GetContext(ctx, &mail, `
+		SELECT m.*, u.email, u.first_name, u.last_name FROM mails m
+		INNER JOIN users u ON m.to_user_id = u.id
+		WHERE m.id = $1
+	`, id); err != nil {
+		return model.MailWithUser{}, fmt.Errorf("can't get mail with user: %w", err)
+	}
+
+	return mail, nil
+}
+
+func (s *SqlStorage) RecordOpen(ctx context.Context, event model.OpenEvent) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.NamedExecContext(ctx, `
+		INSERT INTO open_events (mail_id, ip, user_agent, automated)
+		VALUES (:mail_id, :ip, :user_agent, :automated)
+	`, event); err != nil {
+		return fmt.Errorf("can't record open: %w", err)
+	}
+
+	if event.Automated {
+		if err = tx.Commit(); err != nil {
+			return fmt.Errorf("can't commit transaction: %w", err)
+		}
+		return nil
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE mails SET open_count = open_count + 1, first_opened_at = COALESCE(first_opened_at, NOW())
+		WHERE id = $1
+	`, event.MailID); err != nil {
+		return fmt.Errorf("can't update open count: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) GetOpenEvents(ctx context.Context, mailID uuid.UUID) ([]model.OpenEvent, error) {
+	var events []model.OpenEvent
+
+	if err := s.db.SelectContext(ctx, &events, `
+		SELECT * FROM open_events WHERE mail_id = $1 ORDER BY opened_at
+	`, mailID); err != nil {
+		return nil, fmt.Errorf("can't get open events: %w", err)
+	}
+
+	return events, nil
+}
+
+func (s *SqlStorage) CreateLink(ctx context.Context, link model.Link) (uuid.UUID, error) {
 	result, err := s.db.NamedQueryContext(ctx, `
-		INSERT INTO groups (name)
-		VALUES (:name)
+		INSERT INTO links (mail_id, url)
+		VALUES (:mail_id, :url)
 		RETURNING id
-	`, group)
+	`, link)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("can't create group: %w", err)
+		return uuid.Nil, fmt.Errorf("can't create link: %w", err)
 	}
+	defer result.Close()
 
 	var id uuid.UUID
 
@@ -92,78 +1409,125 @@ func (s *SqlStorage) CreateGroup(ctx context.Context, group model.Group) (uuid.U
 			return uuid.Nil, fmt.Errorf("can't get id: %w", err)
 		}
 	}
+	if err = result.Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create link: %w", err)
+	}
 
 	return id, nil
 }
 
-func (s *SqlStorage) GetGroupById(ctx context.Context, id uuid.UUID) (model.Group, error) {
-	var group model.Group
+func (s *SqlStorage) GetLink(ctx context.Context, id uuid.UUID) (model.Link, error) {
+	var link model.Link
 
-	if err := s.db.GetContext(ctx, &group, `
-		SELECT * FROM groups WHERE id = $1
+	if err := s.db.GetContext(ctx, &link, `
+		SELECT * FROM links WHERE id = $1
 	`, id); err != nil {
-		return model.Group{}, fmt.Errorf("can't get group: %w", err)
+		return model.Link{}, fmt.Errorf("can't get link: %w", err)
 	}
 
-	return group, nil
+	return link, nil
 }
 
-func (s *SqlStorage) GetGroupByName(ctx context.Context, name string) (model.Group, error) {
-	var group model.Group
+func (s *SqlStorage) RecordClick(ctx context.Context, event model.ClickEvent) error {
+	if _, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO click_events (link_id, mail_id, url, ip, user_agent)
+		VALUES (:link_id, :mail_id, :url, :ip, :user_agent)
+	`, event); err != nil {
+		return fmt.Errorf("can't record click: %w", err)
+	}
 
-	if err := s.db.GetContext(ctx, &group, `
-		SELECT * FROM groups WHERE name = $1
-	`, name); err != nil {
-		return model.Group{}, fmt.Errorf("can't get group: %w", err)
+	return nil
+}
+
+func (s *SqlStorage) GetClickEvents(ctx context.Context, mailID uuid.UUID) ([]model.ClickEvent, error) {
+	var events []model.ClickEvent
+
+	if err := s.db.SelectContext(ctx, &events, `
+		SELECT * FROM click_events WHERE mail_id = $1 ORDER BY clicked_at
+	`, mailID); err != nil {
+		return nil, fmt.Errorf("can't get click events: %w", err)
 	}
 
-	return group, nil
+	return events, nil
 }
 
-func (s *SqlStorage) AddUserToGroup(ctx context.Context, userID, groupID uuid.UUID) error {
-	if _, err := s.db.ExecContext(ctx, `
-		INSERT INTO users_groups (user_id, group_id)
-		VALUES ($1, $2)
-	`, userID, groupID); err != nil {
-		return fmt.Errorf("can't add user to group: %w", err)
+// statsColumns aggregates delivery and engagement counters over mails m
+// joined with their click counts c. A mail is counted as sent by its sent_at,
+// a bounce changes its status later.
+const statsColumns = `
+	COUNT(*) FILTER (WHERE m.sent_at IS NOT NULL) AS sent,
+	COUNT(*) FILTER (WHERE m.status = 'failed') AS failed,
+	COUNT(*) FILTER (WHERE m.open_count > 0) AS opened,
+	COALESCE(SUM(m.open_count), 0) AS opens,
+	COUNT(*) FILTER (WHERE c.clicks > 0) AS clicked,
+	COALESCE(SUM(c.clicks), 0) AS clicks
+`
+
+const statsClicksJoin = `
+	LEFT JOIN LATERAL (
+		SELECT COUNT(*) AS clicks FROM click_events WHERE mail_id = m.id
+	) c ON TRUE
+`
+
+func (s *SqlStorage) GetStatsByTime(ctx context.Context, bucket string, from, to time.Time) ([]model.TimeStats, error) {
+	stats := []model.TimeStats{}
+
+	if err := s.db.SelectContext(ctx, &stats, `
+		SELECT date_trunc($1, m.created_at) AS bucket, `+statsColumns+`
+		FROM mails m `+statsClicksJoin+`
+		WHERE m.created_at >= $2 AND m.created_at < $3
+		GROUP BY 1
+		ORDER BY 1
+	`, bucket, from, to); err != nil {
+		return nil, fmt.Errorf("can't get stats by time: %w", err)
 	}
 
-	return nil
+	return stats, nil
 }
 
-func (s *SqlStorage) RemoveUserFromGroup(ctx context.Context, userID, groupID uuid.UUID) error {
-	if _, err := s.db.ExecContext(ctx, `
-		DELETE FROM users_groups WHERE user_id = $1 AND group_id = $2
-	`, userID, groupID); err != nil {
-		return fmt.Errorf("can't remove user from group: %w", err)
+func (s *SqlStorage) GetStatsByGroup(ctx context.Context, from, to time.Time) ([]model.GroupStats, error) {
+	stats := []model.GroupStats{}
+
+	if err := s.db.SelectContext(ctx, &stats, `
+		SELECT g.id AS group_id, g.name, `+statsColumns+`
+		FROM mails m `+statsClicksJoin+`
+		INNER JOIN groups g ON m.group_id = g.id
+		WHERE m.created_at >= $1 AND m.created_at < $2
+		GROUP BY g.id, g.name
+		ORDER BY g.name
+	`, from, to); err != nil {
+		return nil, fmt.Errorf("can't get stats by group: %w", err)
 	}
 
-	return nil
+	return stats, nil
 }
 
-func (s *SqlStorage) GetUsersByGroup(ctx context.Context, groupID uuid.UUID) ([]model.User, error) {
-	var users []model.User
+func (s *SqlStorage) GetStatsByTemplate(ctx context.Context, from, to time.Time) ([]model.TemplateStats, error) {
+	stats := []model.TemplateStats{}
 
-	if err := s.db.SelectContext(ctx, &users, `
-		SELECT u.* FROM users u
-		INNER JOIN users_groups ug ON u.id = ug.user_id
-		WHERE ug.group_id = $1
-	`, groupID); err != nil {
-		return nil, fmt.Errorf("can't get users by group: %w", err)
+	if err := s.db.SelectContext(ctx, &stats, `
+		SELECT m.template, `+statsColumns+`
+		FROM mails m `+statsClicksJoin+`
+		WHERE m.created_at >= $1 AND m.created_at < $2
+		GROUP BY m.template
+		ORDER BY m.template
+	`, from, to); err != nil {
+		return nil, fmt.Errorf("can't get stats by template: %w", err)
 	}
 
-	return users, nil
+	return stats, nil
 }
 
-func (s *SqlStorage) CreateMail(ctx context.Context, mail model.Mail) (uuid.UUID, error) {
+func (s *SqlStorage) CreateWebhook(ctx context.Context, webhook model.Webhook) (uuid.UUID, error) {
 	result, err := s.db.NamedQueryContext(ctx, `
-		INSERT INTO mails (subject, body, to_user_id)
-		VALUES (:subject, :body, :to_user_id)
+		INSERT INTO webhooks (url, events, secret)
+		VALUES (:url, :events, :secret)
 		RETURNING id
-	`, mail)
+	`, webhook)
 	if err != nil {
-		return uuid.Nil, fmt.Errorf("can't create mail: %w", err)
+		return uuid.Nil, fmt.Errorf("can't create webhook: %w", err)
 	}
+	defer result.Close()
 
 	var id uuid.UUID
 
@@ -172,64 +1536,317 @@ func (s *SqlStorage) CreateMail(ctx context.Context, mail model.Mail) (uuid.UUID
 			return uuid.Nil, fmt.Errorf("can't get id: %w", err)
 		}
 	}
+	if err = result.Err(); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create webhook: %w", err)
+	}
 
 	return id, nil
 }
 
-func (s *SqlStorage) MarkAsSent(ctx context.Context, mailID uuid.UUID, time time.Time) error {
-	if _, err := s.db.ExecContext(ctx, `
-		UPDATE mails SET sent_at = $1 WHERE id = $2
-	`, time, mailID); err != nil {
-		return fmt.Errorf("can't mark as sent: %w", err)
+func (s *SqlStorage) GetWebhook(ctx context.Context, id uuid.UUID) (model.Webhook, error) {
+	var webhook model.Webhook
+
+	if err := s.db.GetContext(ctx, &webhook, `
+		SELECT * FROM webhooks WHERE id = $1
+	`, id); err != nil {
+		return model.Webhook{}, fmt.Errorf("can't get webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+func (s *SqlStorage) GetWebhooks(ctx context.Context) ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+
+	if err := s.db.SelectContext(ctx, &webhooks, `
+		SELECT * FROM webhooks ORDER BY created_at
+	`); err != nil {
+		return nil, fmt.Errorf("can't get webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+func (s *SqlStorage) GetWebhooksByEvent(ctx context.Context, event string) ([]model.Webhook, error) {
+	var webhooks []model.Webhook
+
+	if err := s.db.SelectContext(ctx, &webhooks, `
+		SELECT * FROM webhooks WHERE $1 = ANY(events)
+	`, event); err != nil {
+		return nil, fmt.Errorf("can't get webhooks by event: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+func (s *SqlStorage) DeleteWebhook(ctx context.Context, id uuid.UUID) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM webhooks WHERE id = $1
+	`, id)
+	if err != nil {
+		return fmt.Errorf("can't delete webhook: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't delete webhook: %w", sql.ErrNoRows)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error {
+	if _, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO webhook_deliveries (webhook_id, event, payload, attempt, status_code, error)
+		VALUES (:webhook_id, :event, :payload, :attempt, :status_code, :error)
+	`, delivery); err != nil {
+		return fmt.Errorf("can't create webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID) ([]model.WebhookDelivery, error) {
+	var deliveries []model.WebhookDelivery
+
+	if err := s.db.SelectContext(ctx, &deliveries, `
+		SELECT * FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY created_at DESC
+	`, webhookID); err != nil {
+		return nil, fmt.Errorf("can't get webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *SqlStorage) CreateBounce(ctx context.Context, bounce model.Bounce) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.NamedExecContext(ctx, `
+		INSERT INTO bounces (mail_id, recipient, bounce_type, status, diagnostic_code)
+		VALUES (:mail_id, :recipient, :bounce_type, :status, :diagnostic_code)
+	`, bounce); err != nil {
+		return fmt.Errorf("can't create bounce: %w", err)
+	}
+
+	if bounce.BounceType == model.BounceHard {
+		if _, err = tx.ExecContext(ctx, `
+			UPDATE mails SET status = 'bounced', error = $1 WHERE id = $2
+		`, bounce.DiagnosticCode, bounce.MailID); err != nil {
+			return fmt.Errorf("can't mark as bounced: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
 	}
 
 	return nil
 }
 
-func (s *SqlStorage) MarkAsWatched(ctx context.Context, mailID uuid.UUID) error {
+func (s *SqlStorage) AddSuppression(ctx context.Context, suppression model.Suppression) error {
 	if _, err := s.db.ExecContext(ctx, `
-		UPDATE mails SET watched = TRUE WHERE id = $1
-	`, mailID); err != nil {
-		return fmt.Errorf("can't mark as watched: %w", err)
+		INSERT INTO suppressions (address, reason, source)
+		VALUES (LOWER($1), $2, $3)
+		ON CONFLICT (address) DO NOTHING
+	`, suppression.Address, suppression.Reason, suppression.Source); err != nil {
+		return fmt.Errorf("can't add suppression: %w", err)
 	}
 
 	return nil
 }
 
-func (s *SqlStorage) GetMailById(ctx context.Context, id uuid.UUID) (model.Mail, error) {
-	var mail model.Mail
+func (s *SqlStorage) RemoveSuppression(ctx context.Context, address string) error {
+	result, err := s.db.ExecContext(ctx, `
+		DELETE FROM suppressions WHERE address IN (LOWER($1), $2)
+	`, address, model.HashAddress(address))
+	if err != nil {
+		return fmt.Errorf("can't remove suppression: %w", err)
+	}
 
-	if err := s.db.GetContext(ctx, &mail, `
-		SELECT * FROM mails WHERE id = $1
-	`, id); err != nil {
-		return model.Mail{}, fmt.Errorf("can't get mail: %w", err)
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("can't remove suppression: %w", sql.ErrNoRows)
 	}
 
-	return mail, nil
+	return nil
 }
 
-func (s *SqlStorage) GetMailsBySentTo(ctx context.Context, receiverID uuid.UUID) ([]model.Mail, error) {
-	var mails []model.Mail
+func (s *SqlStorage) GetSuppressions(ctx context.Context) ([]model.Suppression, error) {
+	var suppressions []model.Suppression
 
-	if err := s.db.SelectContext(ctx, &mails, `
-		SELECT * FROM mails WHERE to_user_id = $1
-	`, receiverID); err != nil {
-		return nil, fmt.Errorf("can't get mails by receiver: %w", err)
+	if err := s.db.SelectContext(ctx, &suppressions, `
+		SELECT * FROM suppressions ORDER BY created_at DESC
+	`); err != nil {
+		return nil, fmt.Errorf("can't get suppressions: %w", err)
 	}
 
-	return mails, nil
+	return suppressions, nil
 }
 
-func (s *SqlStorage) GetMailWithUser(ctx context.Context, id uuid.UUID) (model.MailWithUser, error) {
-	var mail model.MailWithUser
+func (s *SqlStorage) IsSuppressed(ctx context.Context, address string) (bool, error) {
+	var suppressed bool
 
-	if err := s.db.GetContext(ctx, &mail, `
-		SELECT m.*, u.email, u.first_name, u.last_name FROM mails m
-		INNER JOIN users u ON m.to_user_id = u.id
-		WHERE m.id = $1
+	if err := s.db.GetContext(ctx, &suppressed, `
+		SELECT EXISTS (SELECT 1 FROM suppressions WHERE address IN (LOWER($1), $2))
+	`, address, model.HashAddress(address)); err != nil {
+		return false, fmt.Errorf("can't check suppression: %w", err)
+	}
+
+	return suppressed, nil
+}
+
+const groupSubscriptionsQuery = `
+	SELECT g.id AS group_id, g.name,
+		EXISTS (SELECT 1 FROM users_groups ug WHERE ug.user_id = $1 AND ug.group_id = g.id)
+		AND NOT EXISTS (SELECT 1 FROM group_unsubscribes gu WHERE gu.user_id = $1 AND gu.group_id = g.id)
+		AS subscribed
+	FROM groups g
+	WHERE EXISTS (SELECT 1 FROM users_groups ug WHERE ug.user_id = $1 AND ug.group_id = g.id)
+		OR EXISTS (SELECT 1 FROM group_unsubscribes gu WHERE gu.user_id = $1 AND gu.group_id = g.id)
+	ORDER BY g.name
+`
+
+// GetGroupSubscriptions returns the groups the user is a member of or has
+// unsubscribed from.
+func (s *SqlStorage) GetGroupSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.GroupSubscription, error) {
+	var subscriptions []model.GroupSubscription
+
+	if err := s.db.SelectContext(ctx, &subscriptions, groupSubscriptionsQuery, userID); err != nil {
+		return nil, fmt.Errorf("can't get group subscriptions: %w", err)
+	}
+
+	return subscriptions, nil
+}
+
+// SetGroupSubscription adds the user to the group or removes them from it and
+// records the change.
+func (s *SqlStorage) SetGroupSubscription(ctx context.Context, userID, groupID uuid.UUID, subscribed bool, source string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if subscribed {
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO users_groups (user_id, group_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, userID, groupID); err != nil {
+			return fmt.Errorf("can't add user to group: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, `
+			DELETE FROM group_unsubscribes WHERE user_id = $1 AND group_id = $2
+		`, userID, groupID); err != nil {
+			return fmt.Errorf("can't remove group unsubscribe: %w", err)
+		}
+	} else {
+		if _, err = tx.ExecContext(ctx, `
+			DELETE FROM users_groups WHERE user_id = $1 AND group_id = $2
+		`, userID, groupID); err != nil {
+			return fmt.Errorf("can't remove user from group: %w", err)
+		}
+		if _, err = tx.ExecContext(ctx, `
+			INSERT INTO group_unsubscribes (user_id, group_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+		`, userID, groupID); err != nil {
+			return fmt.Errorf("can't add group unsubscribe: %w", err)
+		}
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO preference_changes (user_id, group_id, subscribed, source)
+		VALUES ($1, $2, $3, $4)
+	`, userID, groupID, subscribed, source); err != nil {
+		return fmt.Errorf("can't record preference change: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) CreateSubscriptionRequest(ctx context.Context, userID, groupID uuid.UUID, expiresAt time.Time) (uuid.UUID, error) {
+	var id uuid.UUID
+
+	if err := s.db.GetContext(ctx, &id, `
+		INSERT INTO subscription_requests (user_id, group_id, expires_at)
+		VALUES ($1, $2, $3)
+		RETURNING id
+	`, userID, groupID, expiresAt); err != nil {
+		return uuid.Nil, fmt.Errorf("can't create subscription request: %w", err)
+	}
+
+	return id, nil
+}
+
+func (s *SqlStorage) GetSubscriptionRequest(ctx context.Context, id uuid.UUID) (model.SubscriptionRequest, error) {
+	var request model.SubscriptionRequest
+
+	if err := s.db.GetContext(ctx, &request, `
+		SELECT * FROM subscription_requests WHERE id = $1
 	`, id); err != nil {
-		return model.MailWithUser{}, fmt.Errorf("can't get mail with user: %w", err)
+		return model.SubscriptionRequest{}, fmt.Errorf("can't get subscription request: %w", err)
 	}
 
-	return mail, nil
+	return request, nil
+}
+
+// ConfirmSubscriptionRequest activates the membership of a pending request,
+// sql.ErrNoRows is returned if the request is expired or already confirmed.
+func (s *SqlStorage) ConfirmSubscriptionRequest(ctx context.Context, id uuid.UUID) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("can't begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var request model.SubscriptionRequest
+	if err = tx.GetContext(ctx, &request, `
+		UPDATE subscription_requests SET confirmed_at = NOW()
+		WHERE id = $1 AND confirmed_at IS NULL AND expires_at > NOW()
+		RETURNING *
+	`, id); err != nil {
+		return fmt.Errorf("can't confirm subscription request: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		INSERT INTO users_groups (user_id, group_id) VALUES ($1, $2) ON CONFLICT DO NOTHING
+	`, request.UserID, request.GroupID); err != nil {
+		return fmt.Errorf("can't add user to group: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		DELETE FROM group_unsubscribes WHERE user_id = $1 AND group_id = $2
+	`, request.UserID, request.GroupID); err != nil {
+		return fmt.Errorf("can't remove group unsubscribe: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) DeleteExpiredSubscriptionRequests(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM subscription_requests WHERE confirmed_at IS NULL AND expires_at <= NOW()
+	`); err != nil {
+		return fmt.Errorf("can't delete expired subscription requests: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SqlStorage) CreateComplaint(ctx context.Context, complaint model.Complaint) error {
+	if _, err := s.db.NamedExecContext(ctx, `
+		INSERT INTO complaints (mail_id, feedback_type, user_agent)
+		VALUES (:mail_id, :feedback_type, :user_agent)
+	`, complaint); err != nil {
+		return fmt.Errorf("can't create complaint: %w", err)
+	}
+
+	return nil
 }
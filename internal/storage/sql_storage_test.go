@@ -0,0 +1,232 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mail-service/internal/model"
+	"mail-service/internal/segment"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestStorage connects to the database in TEST_DATABASE_URL and applies
+// the schema, the test is skipped without it.
+func newTestStorage(t *testing.T) *SqlStorage {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+
+	s, err := NewSqlStorage(context.Background(), "postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+
+	schema, err := os.ReadFile("../../sql/up.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.db.Exec(string(schema)); err != nil {
+		t.Fatalf("can't apply schema: %v", err)
+	}
+	return s
+}
+
+func TestCompileSegment(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       string
+		want     string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "field",
+			in:       "email = a@b.example",
+			want:     "users.email = $1",
+			wantArgs: []interface{}{"a@b.example"},
+		},
+		{
+			name:     "created_at",
+			in:       "created_at < 2024-01-02",
+			want:     "users.created_at < $1",
+			wantArgs: []interface{}{time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		},
+		{
+			name:     "attribute equality",
+			in:       "plan = pro",
+			want:     "COALESCE(users.attributes -> $1::text = $2::jsonb, FALSE)",
+			wantArgs: []interface{}{"plan", `"pro"`},
+		},
+		{
+			name:     "attribute inequality",
+			in:       "vip != true",
+			want:     "users.attributes -> $1::text IS DISTINCT FROM $2::jsonb",
+			wantArgs: []interface{}{"vip", "true"},
+		},
+		{
+			name: "attribute ordering",
+			in:   "score >= 10",
+			want: "COALESCE(jsonb_typeof(users.attributes -> $1::text) = jsonb_typeof($2::jsonb) " +
+				"AND users.attributes -> $1::text >= $2::jsonb, FALSE)",
+			wantArgs: []interface{}{"score", "10"},
+		},
+		{
+			name:     "boolean operators",
+			in:       "a = 1 AND NOT (b = 2 OR c = 3)",
+			want:     "(COALESCE(users.attributes -> $1::text = $2::jsonb, FALSE) AND NOT ((COALESCE(users.attributes -> $3::text = $4::jsonb, FALSE) OR COALESCE(users.attributes -> $5::text = $6::jsonb, FALSE))))",
+			wantArgs: []interface{}{"a", "1", "b", "2", "c", "3"},
+		},
+		{
+			name: "in group",
+			in:   `in_group("Customers")`,
+			want: `EXISTS ( WITH RECURSIVE included(id) AS ( SELECT id FROM groups WHERE name = $1 UNION ` +
+				`SELECT gi.included_group_id FROM group_includes gi INNER JOIN included i ON gi.group_id = i.id ) ` +
+				`SELECT 1 FROM users_groups ug INNER JOIN included i ON i.id = ug.group_id WHERE ug.user_id = users.id )`,
+			wantArgs: []interface{}{"Customers"},
+		},
+		{
+			name: "opened",
+			in:   "opened(1d)",
+			want: "EXISTS ( SELECT 1 FROM mails m INNER JOIN open_events oe ON oe.mail_id = m.id " +
+				"WHERE m.to_user_id = users.id AND NOT oe.automated AND oe.opened_at >= NOW() - make_interval(secs => $1) )",
+			wantArgs: []interface{}{86400.0},
+		},
+		{
+			name: "received",
+			in:   "received(30m)",
+			want: "EXISTS ( SELECT 1 FROM mails m " +
+				"WHERE m.to_user_id = users.id AND m.sent_at >= NOW() - make_interval(secs => $1) )",
+			wantArgs: []interface{}{1800.0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := segment.Parse(tt.in)
+			if err != nil {
+				t.Fatalf("Parse(%q) error: %v", tt.in, err)
+			}
+
+			var args []interface{}
+			got, err := compileSegment(expr, func(v interface{}) string {
+				args = append(args, v)
+				return fmt.Sprintf("$%d", len(args))
+			})
+			if err != nil {
+				t.Fatalf("compileSegment(%q) error: %v", tt.in, err)
+			}
+
+			if got = strings.Join(strings.Fields(got), " "); got != tt.want {
+				t.Errorf("compileSegment(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("compileSegment(%q) args = %#v, want %#v", tt.in, args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestImportUsersKeepsExistingAttributes(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	suffix := uuid.NewString()
+	existing := "existing-" + suffix + "@example.com"
+	created := "created-" + suffix + "@example.com"
+	rejected := "rejected-" + suffix + "@example.com"
+	t.Cleanup(func() {
+		s.db.Exec(`DELETE FROM users WHERE email IN ($1, $2, $3)`, existing, created, rejected)
+	})
+
+	_, err := s.CreateUser(ctx, model.User{
+		Email:      existing,
+		FirstName:  "Ann",
+		LastName:   "Smith",
+		Timezone:   "Europe/Berlin",
+		Attributes: model.Attributes{"plan": "pro"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	omitted := map[string]bool{"first_name": true, "last_name": true, "timezone": true}
+	rows := []model.ImportRow{
+		{
+			Row:         1,
+			User:        model.User{Email: existing, Attributes: model.Attributes{"score": 5}},
+			Omitted:     omitted,
+			Defaults:    model.Attributes{"plan": "free"},
+			CreateError: `attribute "region" is required`,
+		},
+		{
+			Row:      2,
+			User:     model.User{Email: created, FirstName: "Bob", Timezone: "UTC", Attributes: model.Attributes{"score": 1}},
+			Defaults: model.Attributes{"plan": "free"},
+		},
+		{
+			Row:         3,
+			User:        model.User{Email: rejected, Timezone: "UTC"},
+			Defaults:    model.Attributes{"plan": "free"},
+			CreateError: `attribute "region" is required`,
+		},
+	}
+	next := func() (model.ImportRow, error) {
+		if len(rows) == 0 {
+			return model.ImportRow{}, io.EOF
+		}
+		row := rows[0]
+		rows = rows[1:]
+		return row, nil
+	}
+
+	var results []model.ImportResult
+	err = s.ImportUsers(ctx, uuid.NullUUID{}, next, func(result model.ImportResult) error {
+		results = append(results, result)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var statuses []string
+	for _, result := range results {
+		statuses = append(statuses, result.Status+" "+result.Error)
+	}
+	wantStatuses := []string{"updated ", "created ", `invalid attribute "region" is required`}
+	if !reflect.DeepEqual(statuses, wantStatuses) {
+		t.Errorf("statuses = %q, want %q", statuses, wantStatuses)
+	}
+
+	user, err := s.GetUserByEmail(ctx, existing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.FirstName != "Ann" || user.LastName != "Smith" || user.Timezone != "Europe/Berlin" {
+		t.Errorf("existing user = %+v, want the stored fields kept", user)
+	}
+	if want := (model.Attributes{"plan": "pro", "score": 5.0}); !reflect.DeepEqual(user.Attributes, want) {
+		t.Errorf("existing attributes = %v, want %v", user.Attributes, want)
+	}
+
+	user, err = s.GetUserByEmail(ctx, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (model.Attributes{"plan": "free", "score": 1.0}); !reflect.DeepEqual(user.Attributes, want) {
+		t.Errorf("created attributes = %v, want %v", user.Attributes, want)
+	}
+
+	if _, err = s.GetUserByEmail(ctx, rejected); err == nil {
+		t.Errorf("rejected user was created")
+	}
+}
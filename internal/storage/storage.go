@@ -2,31 +2,137 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"github.com/google/uuid"
 	"mail-service/internal/model"
+	"mail-service/internal/segment"
 	"time"
 )
 
+// ErrConflict is returned when a unique field, e.g. the email of a user,
+// is already taken.
+var ErrConflict = errors.New("already exists")
+
+// ErrCycle is returned when a group would include itself through the groups
+// it includes.
+var ErrCycle = errors.New("group inclusion cycle")
+
 type User interface {
 	CreateUser(ctx context.Context, user model.User) (uuid.UUID, error)
 	GetUser(ctx context.Context, id uuid.UUID) (model.User, error)
 	GetUserByEmail(ctx context.Context, email string) (model.User, error)
+	GetUsers(ctx context.Context, filter model.UserFilter) ([]model.User, error)
+	UpdateUser(ctx context.Context, user model.User) (model.User, error)
+	DeleteUser(ctx context.Context, id uuid.UUID) error
+	ImportUsers(ctx context.Context, groupID uuid.NullUUID, next func() (model.ImportRow, error), report func(model.ImportResult) error) error
+	ExportUser(ctx context.Context, id uuid.UUID) (model.UserArchive, error)
+	EraseUser(ctx context.Context, id uuid.UUID) ([]uuid.UUID, error)
+	NormalizeEmails(ctx context.Context, normalize func(string) string) (int, []string, error)
+}
+
+type Attribute interface {
+	CreateAttributeDefinition(ctx context.Context, definition model.AttributeDefinition) error
+	GetAttributeDefinitions(ctx context.Context) ([]model.AttributeDefinition, error)
+	DeleteAttributeDefinition(ctx context.Context, name string) error
+}
+
+// Segment stores the segments, the expressions are compiled to SQL to
+// resolve the members.
+type Segment interface {
+	CreateSegment(ctx context.Context, segment model.Segment) (uuid.UUID, error)
+	GetSegment(ctx context.Context, id uuid.UUID) (model.Segment, error)
+	GetSegments(ctx context.Context) ([]model.Segment, error)
+	DeleteSegment(ctx context.Context, id uuid.UUID) error
+	PreviewSegment(ctx context.Context, expr segment.Expr, sample int) (model.SegmentPreview, error)
+	GetUsersBySegment(ctx context.Context, expr segment.Expr) ([]model.User, error)
+}
+
+// Export streams all rows to the callback, returning an error from it stops
+// the export.
+type Export interface {
+	ExportUsers(ctx context.Context, fn func(model.UserExport) error) error
+	ExportGroups(ctx context.Context, fn func(model.GroupInfo) error) error
+	ExportMails(ctx context.Context, from, to time.Time, fn func(model.MailExport) error) error
 }
 
 type Group interface {
 	CreateGroup(ctx context.Context, user model.Group) (uuid.UUID, error)
 	GetGroupById(ctx context.Context, id uuid.UUID) (model.Group, error)
 	GetGroupByName(ctx context.Context, email string) (model.Group, error)
+	GetGroups(ctx context.Context, limit, offset int) ([]model.GroupInfo, error)
+	DeleteGroup(ctx context.Context, id uuid.UUID) error
 	AddUserToGroup(ctx context.Context, userID, groupID uuid.UUID) error
 	RemoveUserFromGroup(ctx context.Context, userID, groupID uuid.UUID) error
-	GetUsersByGroup(ctx context.Context, groupID uuid.UUID) ([]model.User, error)
+	AddUsersToGroup(ctx context.Context, groupID uuid.UUID, change model.MembershipChange) ([]model.MembershipResult, error)
+	RemoveUsersFromGroup(ctx context.Context, groupID uuid.UUID, change model.MembershipChange) ([]model.MembershipResult, error)
+	GetUsersByGroup(ctx context.Context, groupID uuid.UUID, after uuid.NullUUID, limit int) ([]model.User, error)
+	GetRecipientsByGroup(ctx context.Context, groupID uuid.UUID, exclude []uuid.UUID) ([]model.User, error)
+	IncludeGroup(ctx context.Context, groupID, includedID uuid.UUID) error
+	RemoveIncludedGroup(ctx context.Context, groupID, includedID uuid.UUID) error
+	GetIncludedGroups(ctx context.Context, groupID uuid.UUID) ([]model.Group, error)
 }
 
 type Mail interface {
 	CreateMail(ctx context.Context, mail model.Mail) (uuid.UUID, error)
 	MarkAsSent(ctx context.Context, id uuid.UUID, time time.Time) error
-	MarkAsWatched(ctx context.Context, id uuid.UUID) error
+	MarkAsFailed(ctx context.Context, id uuid.UUID, reason string) error
+	MarkAsSuppressed(ctx context.Context, id uuid.UUID) error
+	MarkAsDeferred(ctx context.Context, id uuid.UUID, until time.Time) error
+	MarkAsCapped(ctx context.Context, id uuid.UUID) error
+	CountSentMails(ctx context.Context, userID uuid.UUID, category string, since time.Time) (int, error)
 	GetMailById(ctx context.Context, id uuid.UUID) (model.Mail, error)
 	GetMailsBySentTo(ctx context.Context, userID uuid.UUID) ([]model.Mail, error)
 	GetMailWithUser(ctx context.Context, id uuid.UUID) (model.MailWithUser, error)
 }
+
+type Tracking interface {
+	RecordOpen(ctx context.Context, event model.OpenEvent) error
+	GetOpenEvents(ctx context.Context, mailID uuid.UUID) ([]model.OpenEvent, error)
+	CreateLink(ctx context.Context, link model.Link) (uuid.UUID, error)
+	GetLink(ctx context.Context, id uuid.UUID) (model.Link, error)
+	RecordClick(ctx context.Context, event model.ClickEvent) error
+	GetClickEvents(ctx context.Context, mailID uuid.UUID) ([]model.ClickEvent, error)
+}
+
+type Stats interface {
+	GetStatsByTime(ctx context.Context, bucket string, from, to time.Time) ([]model.TimeStats, error)
+	GetStatsByGroup(ctx context.Context, from, to time.Time) ([]model.GroupStats, error)
+	GetStatsByTemplate(ctx context.Context, from, to time.Time) ([]model.TemplateStats, error)
+}
+
+type Webhook interface {
+	CreateWebhook(ctx context.Context, webhook model.Webhook) (uuid.UUID, error)
+	GetWebhook(ctx context.Context, id uuid.UUID) (model.Webhook, error)
+	GetWebhooks(ctx context.Context) ([]model.Webhook, error)
+	GetWebhooksByEvent(ctx context.Context, event string) ([]model.Webhook, error)
+	DeleteWebhook(ctx context.Context, id uuid.UUID) error
+	CreateWebhookDelivery(ctx context.Context, delivery model.WebhookDelivery) error
+	GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID) ([]model.WebhookDelivery, error)
+}
+
+type Bounce interface {
+	CreateBounce(ctx context.Context, bounce model.Bounce) error
+}
+
+type Suppression interface {
+	AddSuppression(ctx context.Context, suppression model.Suppression) error
+	RemoveSuppression(ctx context.Context, address string) error
+	GetSuppressions(ctx context.Context) ([]model.Suppression, error)
+	IsSuppressed(ctx context.Context, address string) (bool, error)
+}
+
+type Preferences interface {
+	GetGroupSubscriptions(ctx context.Context, userID uuid.UUID) ([]model.GroupSubscription, error)
+	SetGroupSubscription(ctx context.Context, userID, groupID uuid.UUID, subscribed bool, source string) error
+}
+
+type Subscription interface {
+	CreateSubscriptionRequest(ctx context.Context, userID, groupID uuid.UUID, expiresAt time.Time) (uuid.UUID, error)
+	GetSubscriptionRequest(ctx context.Context, id uuid.UUID) (model.SubscriptionRequest, error)
+	ConfirmSubscriptionRequest(ctx context.Context, id uuid.UUID) error
+	DeleteExpiredSubscriptionRequests(ctx context.Context) error
+}
+
+type Complaint interface {
+	CreateComplaint(ctx context.Context, complaint model.Complaint) error
+}
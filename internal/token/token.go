@@ -0,0 +1,183 @@
+package token
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Purpose string
+
+const (
+	Open        Purpose = "open"
+	Click       Purpose = "click"
+	Bounce      Purpose = "bounce"
+	Unsubscribe Purpose = "unsubscribe"
+	Preferences Purpose = "preferences"
+	Confirm     Purpose = "confirm"
+)
+
+// permanent purposes are signed without an expiry, unsubscribing has to work
+// for as long as the mail is kept.
+var permanent = map[Purpose]bool{Unsubscribe: true, Preferences: true}
+
+// shortSignatureLen is the length of hex encoded signatures made by SignShort.
+const shortSignatureLen = 16
+
+var (
+	ErrInvalid = errors.New("invalid token")
+	ErrExpired = errors.New("token expired")
+)
+
+// Key is a named HMAC secret. The name is embedded into every token so that
+// old tokens stay verifiable after a new key is put in front of the list.
+type Key struct {
+	ID     string
+	Secret []byte
+}
+
+type Signer struct {
+	keys []Key
+	ttl  time.Duration
+}
+
+// NewSigner creates a signer which signs with the first key and accepts
+// tokens signed with any of them.
+func NewSigner(keys []Key, ttl time.Duration) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is required")
+	}
+	for _, key := range keys {
+		if key.ID == "" || strings.Contains(key.ID, ".") {
+			return nil, fmt.Errorf("invalid key id %q", key.ID)
+		}
+		if len(key.Secret) == 0 {
+			return nil, fmt.Errorf("empty secret for key %q", key.ID)
+		}
+	}
+	return &Signer{keys: keys, ttl: ttl}, nil
+}
+
+// ParseKeys parses keys in the "id:secret,id:secret" form, current key first.
+func ParseKeys(s string) ([]Key, error) {
+	var keys []Key
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q is not in id:secret form", pair)
+		}
+		keys = append(keys, Key{ID: id, Secret: []byte(secret)})
+	}
+	return keys, nil
+}
+
+// Sign returns a token binding the id to the purpose until the signer's ttl
+// passes, unsubscribe and preferences tokens don't expire.
+func (s *Signer) Sign(purpose Purpose, id uuid.UUID) string {
+	key := s.keys[0]
+	var expiresAt int64
+	if !permanent[purpose] {
+		expiresAt = time.Now().Add(s.ttl).Unix()
+	}
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%s:%d", purpose, id.String(), expiresAt)),
+	)
+	signed := key.ID + "." + payload
+	return signed + "." + sign(key.Secret, signed)
+}
+
+// Verify checks the token signature, purpose and expiry and returns the id
+// it was issued for. An expired token with a valid signature returns the id
+// with ErrExpired.
+func (s *Signer) Verify(purpose Purpose, token string) (uuid.UUID, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return uuid.Nil, ErrInvalid
+	}
+
+	key, ok := s.key(parts[0])
+	if !ok {
+		return uuid.Nil, ErrInvalid
+	}
+
+	signed := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(sign(key.Secret, signed)), []byte(parts[2])) {
+		return uuid.Nil, ErrInvalid
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return uuid.Nil, ErrInvalid
+	}
+
+	fields := strings.Split(string(payload), ":")
+	if len(fields) != 3 || Purpose(fields[0]) != purpose {
+		return uuid.Nil, ErrInvalid
+	}
+
+	id, err := uuid.Parse(fields[1])
+	if err != nil {
+		return uuid.Nil, ErrInvalid
+	}
+
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return uuid.Nil, ErrInvalid
+	}
+	// Tokens of permanent purposes signed before they became permanent have
+	// an expiry too, it is ignored.
+	if !permanent[purpose] && time.Now().Unix() > expiresAt {
+		return id, ErrExpired
+	}
+
+	return id, nil
+}
+
+// SignShort returns a short signature of the id for places with length
+// limits, such as the local part of an address. It has no expiry and is
+// verified against every key.
+func (s *Signer) SignShort(purpose Purpose, id uuid.UUID) string {
+	return shortSignature(s.keys[0].Secret, purpose, id)
+}
+
+func (s *Signer) VerifyShort(purpose Purpose, id uuid.UUID, signature string) bool {
+	signature = strings.ToLower(signature)
+	for _, key := range s.keys {
+		if hmac.Equal([]byte(shortSignature(key.Secret, purpose, id)), []byte(signature)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Signer) key(id string) (Key, bool) {
+	for _, key := range s.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return Key{}, false
+}
+
+func sign(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func shortSignature(secret []byte, purpose Purpose, id uuid.UUID) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(string(purpose) + ":" + id.String()))
+	return hex.EncodeToString(mac.Sum(nil))[:shortSignatureLen]
+}
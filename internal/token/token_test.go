@@ -0,0 +1,177 @@
+package token
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSigner(t *testing.T, ttl time.Duration, keys ...Key) *Signer {
+	t.Helper()
+
+	if len(keys) == 0 {
+		keys = []Key{{ID: "k1", Secret: []byte("secret")}}
+	}
+	s, err := NewSigner(keys, ttl)
+	if err != nil {
+		t.Fatalf("NewSigner error: %v", err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	id := uuid.New()
+	s := newSigner(t, time.Hour)
+	valid := s.Sign(Click, id)
+	parts := strings.Split(valid, ".")
+
+	tests := []struct {
+		name    string
+		purpose Purpose
+		token   string
+		wantErr error
+	}{
+		{name: "valid", purpose: Click, token: valid},
+		{name: "other purpose", purpose: Open, token: valid, wantErr: ErrInvalid},
+		{name: "tampered payload", purpose: Click, token: parts[0] + "." + parts[1] + "x." + parts[2], wantErr: ErrInvalid},
+		{name: "tampered signature", purpose: Click, token: parts[0] + "." + parts[1] + "." + strings.Repeat("A", len(parts[2])), wantErr: ErrInvalid},
+		{name: "unknown key", purpose: Click, token: "k9." + parts[1] + "." + parts[2], wantErr: ErrInvalid},
+		{name: "missing part", purpose: Click, token: parts[0] + "." + parts[1], wantErr: ErrInvalid},
+		{name: "empty", purpose: Click, token: "", wantErr: ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Verify(tt.purpose, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Verify error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && got != id {
+				t.Errorf("Verify = %s, want %s", got, id)
+			}
+		})
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	id := uuid.New()
+	s := newSigner(t, -time.Minute)
+
+	// An expired click still tells which link it was for.
+	got, err := s.Verify(Click, s.Sign(Click, id))
+	if !errors.Is(err, ErrExpired) || got != id {
+		t.Errorf("Verify = %s, %v, want %s, %v", got, err, id, ErrExpired)
+	}
+
+	for _, purpose := range []Purpose{Unsubscribe, Preferences} {
+		if got, err := s.Verify(purpose, s.Sign(purpose, id)); err != nil || got != id {
+			t.Errorf("Verify(%s) = %s, %v, want %s without an error", purpose, got, err, id)
+		}
+	}
+}
+
+// Unsubscribe tokens issued with an expiry keep working after it.
+func TestVerifyUnsubscribeSignedWithExpiry(t *testing.T) {
+	id := uuid.New()
+	s := newSigner(t, time.Hour)
+
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%s:%s:%d", Unsubscribe, id, time.Now().Add(-time.Minute).Unix())),
+	)
+	old := "k1." + payload + "." + sign([]byte("secret"), "k1."+payload)
+
+	if got, err := s.Verify(Unsubscribe, old); err != nil || got != id {
+		t.Errorf("Verify = %s, %v, want %s without an error", got, err, id)
+	}
+}
+
+func TestVerifyRotatedKeys(t *testing.T) {
+	id := uuid.New()
+	oldKey := Key{ID: "k1", Secret: []byte("old")}
+	newKey := Key{ID: "k2", Secret: []byte("new")}
+
+	old := newSigner(t, time.Hour, oldKey).Sign(Click, id)
+	rotated := newSigner(t, time.Hour, newKey, oldKey)
+
+	if got, err := rotated.Verify(Click, old); err != nil || got != id {
+		t.Errorf("Verify of a token signed with the old key = %s, %v", got, err)
+	}
+	if !strings.HasPrefix(rotated.Sign(Click, id), "k2.") {
+		t.Error("new tokens are not signed with the first key")
+	}
+	if _, err := newSigner(t, time.Hour, newKey).Verify(Click, old); !errors.Is(err, ErrInvalid) {
+		t.Errorf("Verify with the old key removed error = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestShortSignature(t *testing.T) {
+	id := uuid.New()
+	oldKey := Key{ID: "k1", Secret: []byte("old")}
+	newKey := Key{ID: "k2", Secret: []byte("new")}
+
+	signature := newSigner(t, time.Hour, oldKey).SignShort(Bounce, id)
+	if len(signature) != shortSignatureLen {
+		t.Errorf("signature length = %d, want %d", len(signature), shortSignatureLen)
+	}
+
+	rotated := newSigner(t, time.Hour, newKey, oldKey)
+	if !rotated.VerifyShort(Bounce, id, strings.ToUpper(signature)) {
+		t.Error("VerifyShort rejects a signature made with an old key")
+	}
+	if rotated.VerifyShort(Bounce, uuid.New(), signature) {
+		t.Error("VerifyShort accepts the signature of another id")
+	}
+	if rotated.VerifyShort(Unsubscribe, id, signature) {
+		t.Error("VerifyShort accepts the signature of another purpose")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []Key
+		wantErr bool
+	}{
+		{in: "k2:new, k1:old:with:colons", want: []Key{{ID: "k2", Secret: []byte("new")}, {ID: "k1", Secret: []byte("old:with:colons")}}},
+		{in: "k1:secret,", want: []Key{{ID: "k1", Secret: []byte("secret")}}},
+		{in: "", want: nil},
+		{in: "nosecret", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseKeys(tt.in)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseKeys error = %v, want error %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKeys = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSignerErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []Key
+	}{
+		{name: "no keys"},
+		{name: "empty id", keys: []Key{{Secret: []byte("s")}}},
+		{name: "dot in id", keys: []Key{{ID: "k.1", Secret: []byte("s")}}},
+		{name: "empty secret", keys: []Key{{ID: "k1"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewSigner(tt.keys, time.Hour); err == nil {
+				t.Error("NewSigner succeeded")
+			}
+		})
+	}
+}
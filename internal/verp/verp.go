@@ -0,0 +1,56 @@
+package verp
+
+import (
+	"fmt"
+	"github.com/google/uuid"
+	"mail-service/internal/token"
+	"strings"
+)
+
+const localPart = "bounce"
+
+// Encoder builds variable envelope return paths in the
+// "bounce+{mail_id}.{signature}@domain" form, so a bounce can be tied to the
+// mail it was sent for and can't be forged for other mails.
+type Encoder struct {
+	domain string
+	signer *token.Signer
+}
+
+func NewEncoder(domain string, signer *token.Signer) *Encoder {
+	return &Encoder{domain: strings.ToLower(domain), signer: signer}
+}
+
+func (e *Encoder) Address(mailId uuid.UUID) string {
+	return fmt.Sprintf("%s+%s.%s@%s", localPart, mailId.String(), e.signer.SignShort(token.Bounce, mailId), e.domain)
+}
+
+// Parse returns the mail id of the return path or an error if the address
+// doesn't belong to the domain or its signature doesn't match.
+func (e *Encoder) Parse(address string) (uuid.UUID, error) {
+	local, domain, ok := strings.Cut(strings.Trim(address, "<>"), "@")
+	if !ok || strings.ToLower(domain) != e.domain {
+		return uuid.Nil, fmt.Errorf("address %q is not in the bounce domain", address)
+	}
+
+	prefix, tag, ok := strings.Cut(local, "+")
+	if !ok || !strings.EqualFold(prefix, localPart) {
+		return uuid.Nil, fmt.Errorf("address %q is not a return path", address)
+	}
+
+	rawId, signature, ok := strings.Cut(tag, ".")
+	if !ok {
+		return uuid.Nil, fmt.Errorf("address %q has no signature", address)
+	}
+
+	id, err := uuid.Parse(rawId)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("address %q has invalid mail id: %w", address, err)
+	}
+
+	if !e.signer.VerifyShort(token.Bounce, id, signature) {
+		return uuid.Nil, fmt.Errorf("address %q has invalid signature", address)
+	}
+
+	return id, nil
+}
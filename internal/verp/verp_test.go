@@ -0,0 +1,66 @@
+package verp
+
+import (
+	"github.com/google/uuid"
+	"mail-service/internal/token"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncoder(t *testing.T) {
+	key := token.Key{ID: "k1", Secret: []byte("secret")}
+	signer, err := token.NewSigner([]token.Key{key}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := NewEncoder("Bounces.Example.com", signer)
+
+	id := uuid.New()
+	address := e.Address(id)
+	if !strings.HasPrefix(address, "bounce+"+id.String()+".") || !strings.HasSuffix(address, "@bounces.example.com") {
+		t.Fatalf("Address = %q", address)
+	}
+
+	local, _, _ := strings.Cut(address, "@")
+	signature := local[strings.LastIndexByte(local, '.')+1:]
+	other := uuid.New()
+
+	// A new key in front keeps the addresses signed with the old one valid.
+	rotatedSigner, err := token.NewSigner([]token.Key{{ID: "k2", Secret: []byte("new")}, key}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rotated := NewEncoder("bounces.example.com", rotatedSigner)
+
+	tests := []struct {
+		name    string
+		e       *Encoder
+		address string
+		wantErr bool
+	}{
+		{name: "valid", e: e, address: address},
+		{name: "angle brackets", e: e, address: "<" + address + ">"},
+		{name: "case changed by a server", e: e, address: strings.ToUpper(address)},
+		{name: "rotated key", e: rotated, address: address},
+		{name: "other domain", e: e, address: local + "@example.com", wantErr: true},
+		{name: "other local part", e: e, address: "postmaster@bounces.example.com", wantErr: true},
+		{name: "other prefix", e: e, address: strings.Replace(address, "bounce+", "return+", 1), wantErr: true},
+		{name: "no signature", e: e, address: "bounce+" + id.String() + "@bounces.example.com", wantErr: true},
+		{name: "invalid id", e: e, address: "bounce+123." + signature + "@bounces.example.com", wantErr: true},
+		{name: "signature of another mail", e: e, address: "bounce+" + other.String() + "." + signature + "@bounces.example.com", wantErr: true},
+		{name: "no at sign", e: e, address: local, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.e.Parse(tt.address)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Parse(%q) error = %v, want error %v", tt.address, err, tt.wantErr)
+			}
+			if err == nil && got != id {
+				t.Errorf("Parse(%q) = %s, want %s", tt.address, got, id)
+			}
+		})
+	}
+}
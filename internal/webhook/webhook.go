@@ -0,0 +1,180 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"log"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxAttempts  = 5
+	firstBackoff = time.Second
+	eventsBuffer = 1024
+)
+
+type Event struct {
+	Type      string    `json:"type"`
+	MailID    uuid.UUID `json:"mail_id"`
+	Time      time.Time `json:"time"`
+	URL       string    `json:"url,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	Automated bool      `json:"automated,omitempty"`
+}
+
+// Emitter accepts events without blocking the caller.
+type Emitter interface {
+	Emit(event Event)
+}
+
+type Dispatcher struct {
+	webhooks storage.Webhook
+	client   *http.Client
+	backoff  time.Duration
+
+	events chan Event
+	stop   chan struct{}
+}
+
+func NewDispatcher(webhooks storage.Webhook, client *http.Client) *Dispatcher {
+	return &Dispatcher{
+		webhooks: webhooks,
+		client:   client,
+		backoff:  firstBackoff,
+		events:   make(chan Event, eventsBuffer),
+		stop:     make(chan struct{}),
+	}
+}
+
+// Emit queues the event for delivery, the event is dropped if the queue is
+// full.
+func (d *Dispatcher) Emit(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	select {
+	case d.events <- event:
+	default:
+		log.Printf("webhook queue is full, dropping %s event for mail %s", event.Type, event.MailID)
+	}
+}
+
+func (d *Dispatcher) Run() {
+	for {
+		select {
+		case event := <-d.events:
+			d.dispatch(event)
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) Stop() {
+	close(d.stop)
+}
+
+func (d *Dispatcher) dispatch(event Event) {
+	webhooks, err := d.webhooks.GetWebhooksByEvent(context.Background(), event.Type)
+	if err != nil {
+		log.Printf("can't get webhooks: %v", err)
+		return
+	}
+	if len(webhooks) == 0 {
+		return
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("can't marshal event: %v", err)
+		return
+	}
+
+	for _, webhook := range webhooks {
+		go d.deliver(webhook, event.Type, payload)
+	}
+}
+
+// deliver posts the payload until the receiver answers with 2xx, doubling the
+// delay after every failed attempt. Each attempt is written to the delivery log.
+func (d *Dispatcher) deliver(webhook model.Webhook, eventType string, payload []byte) {
+	backoff := d.backoff
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		delivery := model.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     eventType,
+			Payload:   string(payload),
+			Attempt:   attempt,
+		}
+
+		statusCode, err := d.post(webhook, eventType, payload)
+		if statusCode != 0 {
+			delivery.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+		}
+		if err != nil {
+			delivery.Error = sql.NullString{String: err.Error(), Valid: true}
+		}
+
+		if logErr := d.webhooks.CreateWebhookDelivery(context.Background(), delivery); logErr != nil {
+			log.Printf("can't log webhook delivery: %v", logErr)
+		}
+
+		if err == nil {
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+			backoff *= 2
+		case <-d.stop:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) post(webhook model.Webhook, eventType string, payload []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return 0, fmt.Errorf("can't create request: %w", err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", eventType)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+Sign(webhook.Secret, timestamp, payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("can't send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of "timestamp.payload", receivers
+// compute the same value to check the X-Webhook-Signature header.
+func Sign(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,252 @@
+package webhook
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"mail-service/internal/model"
+	"mail-service/internal/storage"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testBackoff = 20 * time.Millisecond
+
+type fakeWebhooks struct {
+	storage.Webhook
+	webhooks []model.Webhook
+
+	mu         sync.Mutex
+	deliveries []model.WebhookDelivery
+}
+
+func (f *fakeWebhooks) GetWebhooksByEvent(_ context.Context, _ string) ([]model.Webhook, error) {
+	return f.webhooks, nil
+}
+
+func (f *fakeWebhooks) CreateWebhookDelivery(_ context.Context, delivery model.WebhookDelivery) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deliveries = append(f.deliveries, delivery)
+	return nil
+}
+
+func (f *fakeWebhooks) logged() []model.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]model.WebhookDelivery(nil), f.deliveries...)
+}
+
+// receiver answers with the given statuses in turn and records when each
+// request came.
+type receiver struct {
+	statuses []int
+
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	times    []time.Time
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.requests = append(rc.requests, r)
+	rc.bodies = append(rc.bodies, body)
+	rc.times = append(rc.times, time.Now())
+
+	status := rc.statuses[len(rc.statuses)-1]
+	if n := len(rc.requests); n <= len(rc.statuses) {
+		status = rc.statuses[n-1]
+	}
+	w.WriteHeader(status)
+}
+
+func newDispatcher(webhooks storage.Webhook) *Dispatcher {
+	d := NewDispatcher(webhooks, http.DefaultClient)
+	d.backoff = testBackoff
+	return d
+}
+
+func TestSign(t *testing.T) {
+	// printf '1700000000.{"a":1}' | openssl dgst -sha256 -hmac secret
+	want := "49f24e537407743fa4a0242bb63b94b9a47ee99cbbe071ccd8a22550ae411686"
+	if got := Sign("secret", "1700000000", []byte(`{"a":1}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestDeliverSignsRequest(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusNoContent}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhooks := &fakeWebhooks{}
+	webhook := model.Webhook{ID: uuid.New(), URL: server.URL, Secret: "secret"}
+	payload := []byte(`{"type":"sent"}`)
+
+	newDispatcher(webhooks).deliver(webhook, model.EventMailSent, payload)
+
+	if len(rc.requests) != 1 {
+		t.Fatalf("got %d requests, want 1", len(rc.requests))
+	}
+	r := rc.requests[0]
+	if got := r.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := r.Header.Get("X-Webhook-Event"); got != model.EventMailSent {
+		t.Errorf("X-Webhook-Event = %q, want %q", got, model.EventMailSent)
+	}
+	timestamp := r.Header.Get("X-Webhook-Timestamp")
+	if want := "sha256=" + Sign("secret", timestamp, payload); r.Header.Get("X-Webhook-Signature") != want {
+		t.Errorf("X-Webhook-Signature = %q, want %q", r.Header.Get("X-Webhook-Signature"), want)
+	}
+	if string(rc.bodies[0]) != string(payload) {
+		t.Errorf("body = %s, want %s", rc.bodies[0], payload)
+	}
+}
+
+func TestDeliverRetries(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []int
+		want     []sql.NullInt32
+	}{
+		{
+			name:     "first attempt succeeds",
+			statuses: []int{http.StatusOK},
+			want:     []sql.NullInt32{{Int32: 200, Valid: true}},
+		},
+		{
+			name:     "succeeds after failures",
+			statuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests, http.StatusAccepted},
+			want: []sql.NullInt32{
+				{Int32: 500, Valid: true},
+				{Int32: 429, Valid: true},
+				{Int32: 202, Valid: true},
+			},
+		},
+		{
+			name:     "gives up after max attempts",
+			statuses: []int{http.StatusBadGateway},
+			want: []sql.NullInt32{
+				{Int32: 502, Valid: true},
+				{Int32: 502, Valid: true},
+				{Int32: 502, Valid: true},
+				{Int32: 502, Valid: true},
+				{Int32: 502, Valid: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &receiver{statuses: tt.statuses}
+			server := httptest.NewServer(rc)
+			defer server.Close()
+
+			webhooks := &fakeWebhooks{}
+			webhook := model.Webhook{ID: uuid.New(), URL: server.URL, Secret: "secret"}
+			payload := `{"type":"failed"}`
+
+			newDispatcher(webhooks).deliver(webhook, model.EventMailFailed, []byte(payload))
+
+			deliveries := webhooks.logged()
+			if len(deliveries) != len(tt.want) {
+				t.Fatalf("got %d deliveries, want %d", len(deliveries), len(tt.want))
+			}
+			for i, delivery := range deliveries {
+				want := model.WebhookDelivery{
+					WebhookID:  webhook.ID,
+					Event:      model.EventMailFailed,
+					Payload:    payload,
+					Attempt:    i + 1,
+					StatusCode: tt.want[i],
+				}
+				if tt.want[i].Int32 >= 300 {
+					want.Error = sql.NullString{String: "unexpected status: " + statusText(tt.want[i].Int32), Valid: true}
+				}
+				if !reflect.DeepEqual(delivery, want) {
+					t.Errorf("delivery %d = %+v, want %+v", i, delivery, want)
+				}
+			}
+
+			// The delay doubles after every failed attempt.
+			backoff := testBackoff
+			for i := 1; i < len(rc.times); i++ {
+				if gap := rc.times[i].Sub(rc.times[i-1]); gap < backoff {
+					t.Errorf("attempt %d came %v after the previous one, want at least %v", i+1, gap, backoff)
+				}
+				backoff *= 2
+			}
+		})
+	}
+}
+
+func TestDeliverUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	webhooks := &fakeWebhooks{}
+	newDispatcher(webhooks).deliver(model.Webhook{ID: uuid.New(), URL: url}, model.EventMailSent, []byte(`{}`))
+
+	deliveries := webhooks.logged()
+	if len(deliveries) != maxAttempts {
+		t.Fatalf("got %d deliveries, want %d", len(deliveries), maxAttempts)
+	}
+	for _, delivery := range deliveries {
+		if delivery.StatusCode.Valid || !delivery.Error.Valid {
+			t.Errorf("delivery = %+v, want an error without a status code", delivery)
+		}
+	}
+}
+
+func TestRunDeliversEvents(t *testing.T) {
+	rc := &receiver{statuses: []int{http.StatusOK}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhooks := &fakeWebhooks{webhooks: []model.Webhook{{ID: uuid.New(), URL: server.URL, Secret: "secret"}}}
+	d := newDispatcher(webhooks)
+	go d.Run()
+	defer d.Stop()
+
+	event := Event{Type: model.EventMailClicked, MailID: uuid.New(), URL: "https://example.com"}
+	d.Emit(event)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(webhooks.logged()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("event was not delivered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var got Event
+	if err := json.Unmarshal(rc.bodies[0], &got); err != nil {
+		t.Fatalf("can't unmarshal payload: %v", err)
+	}
+	if got.Time.IsZero() {
+		t.Error("event time is not set")
+	}
+	got.Time = time.Time{}
+	if got != event {
+		t.Errorf("payload = %+v, want %+v", got, event)
+	}
+}
+
+func statusText(code int32) string {
+	return fmt.Sprintf("%d %s", code, http.StatusText(int(code)))
+}
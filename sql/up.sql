@@ -1,37 +1,305 @@
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
+-- Tables created by an earlier version of this file are altered right after
+-- their CREATE TABLE statement, on a new database the ALTER statements change
+-- nothing.
+
 CREATE TABLE IF NOT EXISTS "users" (
     id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT subscribers_pkey PRIMARY KEY,
     first_name varchar(255) NOT NULL,
     last_name varchar(255) NOT NULL,
     email TEXT NOT NULL UNIQUE,
+    attributes JSONB NOT NULL DEFAULT '{}',
+    timezone TEXT NOT NULL DEFAULT 'UTC',
     created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';
+
+ALTER TABLE "users"
+    ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'UTC';
+
 CREATE UNIQUE INDEX IF NOT EXISTS "users_email_key" ON "users" (email);
+CREATE INDEX IF NOT EXISTS "users_created_at_index" ON "users" (created_at);
+CREATE INDEX IF NOT EXISTS "users_attributes_index" ON "users" USING GIN (attributes);
+
+CREATE TABLE IF NOT EXISTS "attribute_definitions" (
+    name TEXT NOT NULL CONSTRAINT attribute_definitions_pkey PRIMARY KEY,
+    type TEXT NOT NULL,
+    required BOOLEAN NOT NULL DEFAULT FALSE,
+    default_value JSONB,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
 
 CREATE TABLE IF NOT EXISTS "groups" (
     id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT groups_pkey PRIMARY KEY,
     name TEXT NOT NULL UNIQUE,
+    double_opt_in BOOLEAN NOT NULL DEFAULT FALSE,
     created_at TIMESTAMP NOT NULL DEFAULT NOW()
 );
 
+ALTER TABLE "groups"
+    ADD COLUMN IF NOT EXISTS double_opt_in BOOLEAN NOT NULL DEFAULT FALSE;
+
 CREATE UNIQUE INDEX IF NOT EXISTS "groups_name_key" ON "groups" (name);
 
 CREATE TABLE IF NOT EXISTS "users_groups" (
-    user_id uuid references users NOT NULL,
-    group_id uuid references groups NOT NULL,
+    user_id uuid references users ON DELETE CASCADE NOT NULL,
+    group_id uuid references groups ON DELETE CASCADE NOT NULL,
     CONSTRAINT users_groups_pkey PRIMARY KEY (user_id, group_id)
 );
 
+ALTER TABLE "users_groups"
+    DROP CONSTRAINT IF EXISTS users_groups_user_id_fkey,
+    ADD CONSTRAINT users_groups_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE "users_groups"
+    DROP CONSTRAINT IF EXISTS users_groups_group_id_fkey,
+    ADD CONSTRAINT users_groups_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "users_groups_group_id_index" ON "users_groups" (group_id, user_id);
+
+CREATE TABLE IF NOT EXISTS "group_includes" (
+    group_id uuid references groups ON DELETE CASCADE NOT NULL,
+    included_group_id uuid references groups ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT group_includes_pkey PRIMARY KEY (group_id, included_group_id),
+    CONSTRAINT group_includes_self_check CHECK (group_id <> included_group_id)
+);
+
+CREATE TABLE IF NOT EXISTS "segments" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT segments_pkey PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    expression TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
 CREATE TABLE IF NOT EXISTS "mails" (
     id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT mails_pkey PRIMARY KEY,
-    to_user_id uuid references users NOT NULL,
+    to_user_id uuid references users ON DELETE CASCADE NOT NULL,
+    group_id uuid references groups ON DELETE SET NULL,
+    segment_id uuid references segments ON DELETE SET NULL,
+    template TEXT NOT NULL DEFAULT 'template',
+    priority TEXT NOT NULL DEFAULT 'normal',
+    category TEXT NOT NULL DEFAULT '',
     subject TEXT NOT NULL,
     body TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    error TEXT,
     created_at TIMESTAMP NOT NULL DEFAULT NOW(),
     sent_at TIMESTAMP,
-    watched BOOLEAN NOT NULL DEFAULT FALSE
+    deferred_until TIMESTAMP,
+    first_opened_at TIMESTAMP,
+    open_count INTEGER NOT NULL DEFAULT 0
 );
 
+ALTER TABLE "mails"
+    ADD COLUMN IF NOT EXISTS first_opened_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS open_count INTEGER NOT NULL DEFAULT 0;
+
+DO $$
+BEGIN
+    -- The watched flag is replaced with the open count, a watched mail was
+    -- opened at least once.
+    IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'mails' AND column_name = 'watched') THEN
+        UPDATE "mails" SET open_count = 1 WHERE watched AND open_count = 0;
+        ALTER TABLE "mails" DROP COLUMN watched;
+    END IF;
+END $$;
+
+DO $$
+BEGIN
+    -- Mails sent before the status was recorded keep counting as sent.
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'mails' AND column_name = 'status') THEN
+        ALTER TABLE "mails" ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+        UPDATE "mails" SET status = 'sent' WHERE sent_at IS NOT NULL;
+    END IF;
+END $$;
+
+ALTER TABLE "mails"
+    ADD COLUMN IF NOT EXISTS group_id uuid REFERENCES groups,
+    ADD COLUMN IF NOT EXISTS template TEXT NOT NULL DEFAULT 'template',
+    ADD COLUMN IF NOT EXISTS error TEXT;
+
+ALTER TABLE "mails"
+    DROP CONSTRAINT IF EXISTS mails_to_user_id_fkey,
+    ADD CONSTRAINT mails_to_user_id_fkey FOREIGN KEY (to_user_id) REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE "mails"
+    ADD COLUMN IF NOT EXISTS priority TEXT NOT NULL DEFAULT 'normal',
+    ADD COLUMN IF NOT EXISTS category TEXT NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS deferred_until TIMESTAMP;
+
+ALTER TABLE "mails"
+    DROP CONSTRAINT IF EXISTS mails_group_id_fkey,
+    ADD CONSTRAINT mails_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE SET NULL;
+
+ALTER TABLE "mails"
+    ADD COLUMN IF NOT EXISTS segment_id uuid REFERENCES segments ON DELETE SET NULL;
+
 CREATE INDEX IF NOT EXISTS "mails_to_user_id_index" ON "mails" (to_user_id);
+CREATE INDEX IF NOT EXISTS "mails_created_at_index" ON "mails" (created_at);
+CREATE INDEX IF NOT EXISTS "mails_group_id_created_at_index" ON "mails" (group_id, created_at);
+CREATE INDEX IF NOT EXISTS "mails_template_created_at_index" ON "mails" (template, created_at);
+CREATE INDEX IF NOT EXISTS "mails_to_user_id_category_sent_at_index" ON "mails" (to_user_id, category, sent_at);
+
+CREATE TABLE IF NOT EXISTS "open_events" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT open_events_pkey PRIMARY KEY,
+    mail_id uuid references mails ON DELETE CASCADE NOT NULL,
+    opened_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    automated BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+ALTER TABLE "open_events"
+    ADD COLUMN IF NOT EXISTS automated BOOLEAN NOT NULL DEFAULT FALSE;
+
+ALTER TABLE "open_events"
+    DROP CONSTRAINT IF EXISTS open_events_mail_id_fkey,
+    ADD CONSTRAINT open_events_mail_id_fkey FOREIGN KEY (mail_id) REFERENCES mails ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "open_events_mail_id_index" ON "open_events" (mail_id);
+
+CREATE TABLE IF NOT EXISTS "links" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT links_pkey PRIMARY KEY,
+    mail_id uuid references mails ON DELETE CASCADE NOT NULL,
+    url TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE "links"
+    DROP CONSTRAINT IF EXISTS links_mail_id_fkey,
+    ADD CONSTRAINT links_mail_id_fkey FOREIGN KEY (mail_id) REFERENCES mails ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "links_mail_id_index" ON "links" (mail_id);
+
+CREATE TABLE IF NOT EXISTS "click_events" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT click_events_pkey PRIMARY KEY,
+    link_id uuid references links ON DELETE CASCADE NOT NULL,
+    mail_id uuid references mails ON DELETE CASCADE NOT NULL,
+    url TEXT NOT NULL,
+    clicked_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL
+);
+
+ALTER TABLE "click_events"
+    DROP CONSTRAINT IF EXISTS click_events_link_id_fkey,
+    ADD CONSTRAINT click_events_link_id_fkey FOREIGN KEY (link_id) REFERENCES links ON DELETE CASCADE,
+    DROP CONSTRAINT IF EXISTS click_events_mail_id_fkey,
+    ADD CONSTRAINT click_events_mail_id_fkey FOREIGN KEY (mail_id) REFERENCES mails ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "click_events_mail_id_index" ON "click_events" (mail_id);
+
+CREATE TABLE IF NOT EXISTS "webhooks" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT webhooks_pkey PRIMARY KEY,
+    url TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT webhook_deliveries_pkey PRIMARY KEY,
+    webhook_id uuid references webhooks ON DELETE CASCADE NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempt INTEGER NOT NULL,
+    status_code INTEGER,
+    error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS "webhook_deliveries_webhook_id_index" ON "webhook_deliveries" (webhook_id, created_at);
+
+CREATE TABLE IF NOT EXISTS "bounces" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT bounces_pkey PRIMARY KEY,
+    mail_id uuid references mails ON DELETE CASCADE NOT NULL,
+    recipient TEXT NOT NULL,
+    bounce_type TEXT NOT NULL,
+    status TEXT NOT NULL,
+    diagnostic_code TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE "bounces"
+    DROP CONSTRAINT IF EXISTS bounces_mail_id_fkey,
+    ADD CONSTRAINT bounces_mail_id_fkey FOREIGN KEY (mail_id) REFERENCES mails ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "bounces_mail_id_index" ON "bounces" (mail_id);
+
+CREATE TABLE IF NOT EXISTS "suppressions" (
+    address TEXT NOT NULL CONSTRAINT suppressions_pkey PRIMARY KEY,
+    reason TEXT NOT NULL,
+    source TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS "group_unsubscribes" (
+    user_id uuid references users ON DELETE CASCADE NOT NULL,
+    group_id uuid references groups ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    CONSTRAINT group_unsubscribes_pkey PRIMARY KEY (user_id, group_id)
+);
+
+ALTER TABLE "group_unsubscribes"
+    DROP CONSTRAINT IF EXISTS group_unsubscribes_user_id_fkey,
+    ADD CONSTRAINT group_unsubscribes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE "group_unsubscribes"
+    DROP CONSTRAINT IF EXISTS group_unsubscribes_group_id_fkey,
+    ADD CONSTRAINT group_unsubscribes_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+
+CREATE TABLE IF NOT EXISTS "preference_changes" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT preference_changes_pkey PRIMARY KEY,
+    user_id uuid references users ON DELETE CASCADE NOT NULL,
+    group_id uuid references groups ON DELETE CASCADE NOT NULL,
+    subscribed BOOLEAN NOT NULL,
+    source TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE "preference_changes"
+    DROP CONSTRAINT IF EXISTS preference_changes_user_id_fkey,
+    ADD CONSTRAINT preference_changes_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE "preference_changes"
+    DROP CONSTRAINT IF EXISTS preference_changes_group_id_fkey,
+    ADD CONSTRAINT preference_changes_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "preference_changes_user_id_index" ON "preference_changes" (user_id, created_at);
+
+CREATE TABLE IF NOT EXISTS "subscription_requests" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT subscription_requests_pkey PRIMARY KEY,
+    user_id uuid references users ON DELETE CASCADE NOT NULL,
+    group_id uuid references groups ON DELETE CASCADE NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMP NOT NULL,
+    confirmed_at TIMESTAMP
+);
+
+ALTER TABLE "subscription_requests"
+    DROP CONSTRAINT IF EXISTS subscription_requests_user_id_fkey,
+    ADD CONSTRAINT subscription_requests_user_id_fkey FOREIGN KEY (user_id) REFERENCES users ON DELETE CASCADE;
+
+ALTER TABLE "subscription_requests"
+    DROP CONSTRAINT IF EXISTS subscription_requests_group_id_fkey,
+    ADD CONSTRAINT subscription_requests_group_id_fkey FOREIGN KEY (group_id) REFERENCES groups ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "subscription_requests_expires_at_index" ON "subscription_requests" (expires_at) WHERE confirmed_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS "complaints" (
+    id uuid NOT NULL DEFAULT uuid_generate_v4() CONSTRAINT complaints_pkey PRIMARY KEY,
+    mail_id uuid references mails ON DELETE CASCADE NOT NULL,
+    feedback_type TEXT NOT NULL,
+    user_agent TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT NOW()
+);
+
+ALTER TABLE "complaints"
+    DROP CONSTRAINT IF EXISTS complaints_mail_id_fkey,
+    ADD CONSTRAINT complaints_mail_id_fkey FOREIGN KEY (mail_id) REFERENCES mails ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS "complaints_mail_id_index" ON "complaints" (mail_id);